@@ -13,23 +13,43 @@
 //
 // 3. Call Run() with the "main" module.
 //
-// 4. An injector is created.
+// 4. An injector is created, and the Application, a root context.Context, a Lifecycle and the BuildInfo are bound
+// into it.
 //
 // 5. Module construction...
 //
 // 5.1. Each module (including main) will be installed into the injector.
 //
-// 5.2. If the module implements the Configurable interface, its Configure() method will be called.
+// 5.2. If the module implements the Configurable interface, its Configure() method will be called. Optional modules
+// are only installed and configured after parsing, if enabled.
 //
 // 5.3. Finally, the module will be passed to kingpin.Application.Struct() to provide configuration support.
 //
+// 5.4. All types required by modules are checked to have been bound (see Validate()).
+//
+// 5.5. If a configuration file is set, flag defaults are loaded from it (see ConfigFile()).
+//
 // 6. Kingpin is called to parse the command-line and insert values into the modules.
 //
-// 7. Each module's Start() method (if any) is called via the injector, injecting parameters from modules.
+// 6.1. If a module implements the PostParser interface, its PostParse() method will be called.
+//
+// 6.2. If a module implements the Validator interface, its Validate() method will be called.
+//
+// 6.3. If a module implements the PreStarter interface, its PreStart() method will be called.
+//
+// 7. Each module's Start() method (if any) is called via the injector, injecting parameters from modules. Modules
+// are started in dependency order, including any Before or After constraints (see Order()). BeforeStarter and
+// AfterStarter modules are called, in the same order, before and after all modules are started.
 //
 // 8. The "main".Start() is called to run the application.
 //
-// 9. When "main".Start() returns, run each module's Stop() method (if any).
+// 9. When "main".Start() returns, any provided Runners are run until one returns, then the root context is
+// cancelled. If "main" is Blocking, its Start() instead blocks while the Runners run, and the root context is
+// cancelled once it returns. Run then waits for any Runners started with Lifecycle.Go().
+//
+// 10. Finally, run each module's Stop() method (if any), in reverse dependency order. Errors from Start() and
+// Stop() are returned as Errors. BeforeStopper and AfterStopper modules are called, in the same order, before and
+// after all modules are stopped.
 //
 //
 // Here is a basic example app:
@@ -57,9 +77,12 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"reflect"
+	"sync"
+	"time"
 
 	"gopkg.in/alecthomas/kingpin.v3-unstable"
 
@@ -73,104 +96,572 @@ type Binder = inject.SafeBinder
 type Configurable interface {
 	// Configure the module.
 	//
-	// "binder" may be used to explicitly add bindings to the injector.
+	// Configure is called before the command-line is parsed, so flag values are not yet available. Use PostParser
+	// to bind providers that depend on flag values.
+	//
+	// "binder" may be used to explicitly add bindings to the injector. Modules passed to binder.Install() are
+	// installed into the Application, and will themselves be configured and started, unless a module of the same
+	// type is already installed (see Application.Install()).
 	Configure(binder Binder) error
 }
 
+// A PostParser module is configured a second time, after the command-line has been parsed.
+//
+// It can be used to bind providers depending on flag values, eg. to select between database implementations.
+// As these bindings are not known until after parsing, if any module is a PostParser, missing bindings are only
+// reported once the command-line has been parsed.
+type PostParser interface {
+	// PostParse configures the module with its flag values available.
+	PostParse(binder Binder) error
+}
+
+// A Validator module validates its configuration after command-line parsing.
+//
+// Errors from all modules are returned together, so that every problem can be reported at once.
+type Validator interface {
+	Validate() error
+}
+
+// A PreStarter module is called after command-line parsing, but before any module is started.
+type PreStarter interface {
+	// PreStart the module.
+	//
+	// Returning an error aborts the application before any Start() or Stop() method is called.
+	PreStart(binder Binder) error
+}
+
+// A Deconfigurer module releases resources acquired by its Configure() method if it is never started.
+//
+// This is the case if a later module fails to configure, the command-line fails to parse or validate, or another
+// module fails to start first. Deconfigure() is called in the reverse of the order modules were configured in.
+// Modules that do start are stopped as usual instead.
+type Deconfigurer interface {
+	Deconfigure() error
+}
+
 // Application object.
 type Application struct {
 	*kingpin.Application
-	modules []interface{}
+	modules        []interface{}
+	installed      []interface{}
+	signals        []os.Signal
+	startTimeout   time.Duration
+	stopTimeout    time.Duration
+	drainTimeout   time.Duration
+	holdTimeout    time.Duration
+	startRetries   []startRetry
+	serveBarrier   bool
+	logger         Logger
+	terminate      func(status int)
+	exitHooks      []func() // Guarded by runLock.
+	overrides      []interface{}
+	bindings       []interface{}
+	providers      []interface{}
+	commandModules []commandModules
+	mounts         []mount
+	concurrency    int
+	onEvent        func(Event)
+	callbackLock   sync.Mutex // Serialises calls to onEvent.
+	eventLock      sync.Mutex
+	events         chan Event          // Guarded by eventLock.
+	stats          []ModuleStat        // Guarded by eventLock.
+	statIndex      map[interface{}]int // Guarded by eventLock.
+	commands       []commandModule
+	envar          func(flag string) string
+	configFile     string
+	moduleFlags    map[string][]string
+	configSources  []ConfigSource
+	presetFields   bool
+	structured     []structuredModule
+	secrets        map[string]bool
+	// Flags added for modules by the current and previous Run.
+	moduleClauses  map[*kingpin.Clause]bool
+	reusableFlags  map[*kingpin.Clause]bool
+	flagValues     []flagValue
+	noRecover      bool
+	build          BuildInfo
+	args           []string
+	output         io.Writer
+	errorWriter    io.Writer
+	dryRun         bool
+	reloadSignals  []os.Signal
+	namespaceFlags bool
+	defaultCommand string
+	aliases        []commandAliases
+
+	// Guards the state below, which is only valid while the application is running.
+	lock       sync.Mutex
+	injector   *syncInjector
+	lifecycle  *lifecycle
+	main       interface{}
+	terminated *int
+	reloading  *reloadState
+
+	// Guards the state below, which is set while the application is running. Unlike lock, it is never held while
+	// calling modules.
+	runLock    sync.Mutex
+	runLogger  Logger
+	runCurrent *lifecycle
+
+	// Serialises RestartModule(). Unlike lock, it is held while calling modules.
+	restartLock sync.Mutex
 }
 
-// New creates a new Application instance.
-func New(name, help string) *Application {
+// New creates a new Application instance, configured with the given options.
+func New(name string, options ...Option) *Application {
 	a := &Application{
-		Application: kingpin.New(name, help),
+		Application: kingpin.New(name, ""),
+		terminate:   os.Exit,
+	}
+	a.Application.Terminate(a.handleTerminate)
+	a.addCompletionFlags()
+	a.addManPageFlag()
+	a.addConfigDumpFlag()
+	if version := embeddedBuildInfo().Version; version != "" {
+		a.Version(version)
+	}
+	for _, option := range options {
+		option(a)
 	}
 	return a
 }
 
 // SelectedCommand is available for injection to module Start() functions, as well as the
 // main run() function.
+//
+// It is the full path of the selected command, with the names of nested commands separated by spaces, eg.
+// "db migrate", or empty if no command was selected.
 type SelectedCommand string
 
+// AppInfo describes the application, and is available for injection, eg. to prefix metrics or brand a user agent
+// with the application's name.
+type AppInfo struct {
+	// Name passed to New().
+	Name string
+	// Help set with Help() or WithHelp().
+	Help string
+}
+
 // Help sets the application help.
 func (a *Application) Help(help string) *Application {
 	a.Application.Help = help
 	return a
 }
 
-// Install an application module.
+// DryRun configures Run to return once modules are configured, the command-line is parsed, and bindings, modules
+// and the module order are validated, without starting any module or the application, eg. to check a deployment's
+// configuration in CI.
+//
+// Errors, such as missing bindings, dependency cycles, or modules failing validation, are returned as usual.
+func (a *Application) DryRun(dryRun bool) *Application {
+	a.dryRun = dryRun
+	return a
+}
+
+// Writers sets the writers kingpin writes usage information and errors to, including from Errorf() and Fatalf().
+//
+// Unless a different Logger is set, the application also logs to the error writer.
+func (a *Application) Writers(out, err io.Writer) *Application {
+	a.Application.Writers(out, err)
+	a.output = out
+	a.errorWriter = err
+	return a
+}
+
+// Install application modules.
+//
+// A module may also be a factory function, which will be called with its arguments obtained from the injector and
+// must return the module, optionally followed by an error. Factories are called in install order, so their
+// arguments can only be provided by bindings and previously installed modules. Note that as factories are called
+// before the command-line is parsed, flag values will not yet be available.
+//
+// Installing the same module twice has no effect. Modules of the same concrete type may be installed more than
+// once, unless they implement Singleton. Modules installed from another module's Configure() method are only
+// installed if no module of the same type has been installed, so modules may freely install their dependencies.
+//
+// Install panics if a module is invalid. Use InstallE to have an error returned instead.
 func (a *Application) Install(modules ...interface{}) *Application {
+	if err := a.InstallE(modules...); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// InstallE installs application modules as with Install, returning an error if any module is not a non-nil pointer
+// to a struct or a module factory function. If any module is invalid, none are installed.
+func (a *Application) InstallE(modules ...interface{}) error {
+	for i, module := range modules {
+		if err := validateModule(module); err != nil {
+			return fmt.Errorf("module %d: %s", i+1, err)
+		}
+	}
 	a.modules = append(a.modules, modules...)
+	return nil
+}
+
+// Replace the providers of installed modules with those of the given modules, eg. to replace a database with a
+// fake in tests.
+//
+// Types provided by the overriding modules' Provide*() methods are resolved from them in preference to any other
+// binding, including those made by installed modules. Only their providers are used; overriding modules are not
+// configured or started. Overrides do not apply within the private bindings of Scoped modules.
+func (a *Application) Replace(overrides ...interface{}) *Application {
+	a.overrides = append(a.overrides, overrides...)
+	return a
+}
+
+// Bind values into the injector before any module is installed, eg. to share configuration or a client between
+// modules without a module to provide it.
+//
+// These bindings have the lowest precedence: a module that provides or binds the same type takes precedence over
+// them, as do the framework's own bindings. Use Logger() and RunWithContext() to set the Logger and root context.
+func (a *Application) Bind(values ...interface{}) *Application {
+	a.bindings = append(a.bindings, values...)
+	return a
+}
+
+// Provide adds provider functions to the injector before any module is installed. As with Bind(), providers of
+// installed modules take precedence. The providers may only depend on values added with Bind() and Provide().
+func (a *Application) Provide(providers ...interface{}) *Application {
+	a.providers = append(a.providers, providers...)
 	return a
 }
 
 // Run the given application module's Start(...) method.
 //
-// Its arguments will be obtained from the installed modules.
+// Its arguments will be obtained from the installed modules, and may include any bound type, eg. the root
+// context.Context, SelectedCommand or Lifecycle, or none at all. Like other modules, its Start(...) must return
+// nothing, an error, or values followed by an error.
 func (a *Application) Run(module interface{}) error {
 	return a.RunWithArgs(os.Args[1:], module)
 }
 
+// RunWithContext runs the given application module's Start(...) method, using ctx as the parent of the root
+// context.
+//
+// Its arguments will be obtained from the installed modules.
+func (a *Application) RunWithContext(ctx context.Context, module interface{}) error {
+	return a.run(ctx, os.Args[1:], module)
+}
+
 // RunWithArgs the given application module's Start(...) method.
 //
 // Its arguments will be obtained from the installed modules.
+//
+// An Application may be run repeatedly, though not concurrently, eg. in a test loop. Everything set by its builder
+// methods, such as installed modules, commands and hooks, is kept between runs. Each run has a fresh injector,
+// lifecycle, Values and stats, and module flags are rebound to the fields of the modules of the new run. Module
+// instances are reused as installed, so install module factories to construct fresh modules for each run.
 func (a *Application) RunWithArgs(args []string, module interface{}) error {
-	start := reflect.ValueOf(module).MethodByName("Start")
-	if !start.IsValid() {
-		return fmt.Errorf("no Start(...) method on application module")
+	return a.run(context.Background(), args, module)
+}
+
+// newInjector creates an injector with the bindings provided by the Application itself.
+func (a *Application) newInjector(ctx context.Context, lifecycle *lifecycle) (*syncInjector, error) {
+	injector, err := a.seedInjector()
+	if err != nil {
+		return nil, err
+	}
+	if len(a.overrides) > 0 {
+		if err := injector.override(a.overrides...); err != nil {
+			return nil, fmt.Errorf("replace: %s", err)
+		}
+	}
+	injector.values = &Values{}
+	if err := injector.Bind(a, injector.values); err != nil {
+		return nil, err
+	}
+	if err := injector.Provide(func() context.Context { return ctx }); err != nil {
+		return nil, err
+	}
+	if err := injector.Provide(func() Lifecycle { return lifecycle }); err != nil {
+		return nil, err
+	}
+	if err := injector.Provide(func() Shutdown { return lifecycle.Shutdown }); err != nil {
+		return nil, err
+	}
+	if err := injector.Provide(func() Resolver { return resolver{injector} }); err != nil {
+		return nil, err
+	}
+	if err := injector.Provide(func() Tasks { return &lifecycle.tasks }); err != nil {
+		return nil, err
+	}
+	if err := injector.Provide(func() ShutdownGuard { return shutdownGuard{&lifecycle.holds} }); err != nil {
+		return nil, err
 	}
-	injector := inject.SafeNew()
-	if err := injector.Bind(a); err != nil {
+	if err := injector.Provide(func() Supervisor { return supervisor{app: a, lifecycle: lifecycle} }); err != nil {
+		return nil, err
+	}
+	if err := injector.Bind(a.buildInfo(), AppInfo{Name: a.Name, Help: a.Application.Help}); err != nil {
+		return nil, err
+	}
+	return injector, nil
+}
+
+func (a *Application) run(ctx context.Context, args []string, main interface{}) error {
+	r, err := a.prepare(ctx, args, main, prepareRun)
+	if err != nil {
 		return err
 	}
-	// Configure modules.
-	modules := []interface{}{}
-	modules = append(modules, a.modules...)
-	modules = append(modules, module)
-	for _, module := range modules {
-		if err := injector.Install(module); err != nil {
-			return err
-		}
-		if configurable, ok := module.(Configurable); ok {
-			if err := configurable.Configure(injector); err != nil {
-				return err
+	if a.dryRun {
+		return r.Stop()
+	}
+	// An error from Start() is also returned by Wait(), once any started modules have been stopped.
+	_ = r.Start()
+	return r.Wait()
+}
+
+// How far prepare() prepares the application.
+type prepareMode int
+
+const (
+	// Prepare the application to be started.
+	prepareRun prepareMode = iota
+	// Prepare the application without handling signals or calling PreStart() methods.
+	prepareConfigureOnly
+	// As prepareConfigureOnly, but return once every flag is registered, before the command-line is parsed.
+	prepareModel
+)
+
+// prepare the application to run, up to the point of starting modules.
+func (a *Application) prepare(
+	ctx context.Context, args []string, main interface{}, mode prepareMode,
+) (_ *Runnable, err error) {
+	if main == nil && len(a.commands) == 0 {
+		return nil, fmt.Errorf("no application module")
+	}
+	if main != nil && !startMethod(main).IsValid() {
+		return nil, fmt.Errorf("%w on application module", ErrNoStartMethod)
+	}
+	// The shutdown context is cancelled when the application is shut down, eg. by a signal. The root context,
+	// derived from it, is also cancelled when the main module's Start(...) or a Runner returns.
+	shutdownCtx, shutdown := context.WithCancel(ctx)
+	ctx, cancel := context.WithCancel(shutdownCtx)
+	lifecycle := &lifecycle{ctx: ctx, cancel: shutdown, holding: a.serveBarrier}
+	r := &Runnable{app: a, ctx: ctx, cancel: cancel, shutdown: shutdownCtx, lifecycle: lifecycle}
+	r.cleanups = append(r.cleanups, shutdown, cancel)
+	if mode == prepareRun {
+		r.cleanups = append(r.cleanups, a.handleSignals(shutdown))
+	}
+	defer func() {
+		if err != nil {
+			if r.injector != nil {
+				if errs := a.deconfigure(r.injector, nil); len(errs) > 0 {
+					err = append(Errors{err}, errs...)
+				}
 			}
+			r.cleanup()
+		}
+	}()
+	a.resetStats()
+	a.beginFlags()
+	a.setCurrent(lifecycle)
+	r.cleanups = append(r.cleanups, func() { a.setCurrent(nil) })
+	injector, err := a.newInjector(ctx, lifecycle)
+	if err != nil {
+		return nil, err
+	}
+	r.injector = injector
+	// Configure modules, constructing any installed via factory functions. Optional modules are only configured
+	// once the command-line has been parsed and it is known whether they are enabled, and command modules, and
+	// modules installed with CommandModules(), once the selected command is known.
+	moduleFlags := map[string][]string{}
+	a.moduleFlags = moduleFlags
+	a.secrets = map[string]bool{}
+	a.structured = nil
+	modules, enabled, err := a.configureModules(injector, main, moduleFlags)
+	if err != nil {
+		return nil, err
+	}
+	commandModules, allCommandModules, err := a.prepareCommandModules(injector, modules)
+	if err != nil {
+		return nil, err
+	}
+	validate := append(append([]interface{}{}, modules...), allCommandModules...)
+	if main != nil {
+		validate = append(validate, main)
+	}
+	if err := validateBindings(injector, validate, false); err != nil {
+		return nil, err
+	}
+	for _, command := range a.commands {
+		if err := a.checkModule(command.module); err != nil {
+			return nil, err
+		}
+		if _, err := a.structFlags(command.cmd, command.module, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.checkMounts(); err != nil {
+		return nil, err
+	}
+	if err := a.applyDefaultCommand(); err != nil {
+		return nil, err
+	}
+	if err := a.applyAliases(); err != nil {
+		return nil, err
+	}
+	// Now that every module's flags are registered, generate the completion script or man page if requested.
+	if shell := completionShell(args); shell != "" {
+		if err := a.GenerateCompletion(shell, a.outputWriter()); err != nil {
+			return nil, err
 		}
-		if err := a.Struct(module); err != nil {
-			return err
+		a.handleTerminate(0)
+		return nil, a.checkTerminated()
+	}
+	if hasFlag(args, "help-man") {
+		if err := a.ManPage(a.outputWriter()); err != nil {
+			return nil, err
 		}
+		a.handleTerminate(0)
+		return nil, a.checkTerminated()
+	}
+	if err := a.loadConfigFile(args, moduleFlags); err != nil {
+		return nil, err
+	}
+	presets := a.presets()
+	if err := a.loadConfigSources(); err != nil {
+		return nil, err
+	}
+	a.applyPresets(presets)
+	if err := a.applyFlagValues(); err != nil {
+		return nil, err
+	}
+	a.applyEnvars()
+	if mode == prepareModel {
+		return r, nil
 	}
 	// Parse arguments.
+	_ = a.checkTerminated()
+	a.args = nil
 	command, err := a.Parse(args)
 	if err != nil {
-		return err
+		return nil, ParseError{Err: err}
+	}
+	if err := a.checkTerminated(); err != nil {
+		return nil, err
+	}
+	if hasFlag(args, "config-dump") {
+		if err := a.DumpConfig(a.outputWriter(), "yaml"); err != nil {
+			return nil, err
+		}
+		a.handleTerminate(0)
+		return nil, a.checkTerminated()
+	}
+	parsed, err := a.parseContext(args)
+	if err != nil {
+		return nil, err
+	}
+	// Configure enabled optional modules, and drop disabled ones.
+	active := []interface{}{}
+	for i, module := range modules {
+		if enabled[i] != nil {
+			if !*enabled[i] {
+				continue
+			}
+			if err := a.configureModule(injector, module); err != nil {
+				return nil, err
+			}
+		}
+		active = append(active, module)
+	}
+	// Configure the modules of the selected command.
+	for _, module := range selectedCommandModules(commandModules, command) {
+		if err := a.configureModule(injector, module); err != nil {
+			return nil, err
+		}
+		active = append(active, module)
+	}
+	modules = active
+	a.installed = modules
+	// Select and configure the command's module, if any.
+	for _, cmd := range a.commands {
+		if cmd.cmd.FullCommand() == command {
+			main = cmd.module
+			if err := a.configureModule(injector, main); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if main == nil {
+		return nil, fmt.Errorf("no application module for command %q", command)
+	}
+	injector.values.freeze()
+	start := startMethod(main)
+	if !start.IsValid() {
+		return nil, fmt.Errorf("%w on %T", ErrNoStartMethod, main)
+	}
+	modules = append(modules, main)
+	for _, module := range modules {
+		if postParser, ok := module.(PostParser); ok {
+			err := a.guard(module, "PostParse", func() error { return postParser.PostParse(injector.scope(module)) })
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := a.bindLogger(injector, modules); err != nil {
+		return nil, err
+	}
+	r.cleanups = append(r.cleanups, func() { a.setLogger(nil) })
+	if err := validateBindings(injector, modules, true); err != nil {
+		return nil, err
 	}
 	if err = injector.Bind(SelectedCommand(command)); err != nil {
-		return err
+		return nil, err
+	}
+	if err = injector.Bind(Args(append([]string{}, a.args...)), RawArgs(append([]string{}, args...))); err != nil {
+		return nil, err
+	}
+	if err = injector.Bind(parsed, &Instances{injector: injector, modules: modules}); err != nil {
+		return nil, err
 	}
-	// Call module Start(...) methods.
-	for _, module := range modules[:len(modules)-1] {
-		mv := reflect.ValueOf(module)
-		method := mv.MethodByName("Start")
-		if method.IsValid() {
-			if _, err = injector.Call(method.Interface()); err != nil {
-				return err
+	lifecycle.setCommand(SelectedCommand(command))
+	if err := a.validateModules(modules); err != nil {
+		return nil, err
+	}
+	for _, module := range modules {
+		if prestarter, ok := module.(PreStarter); ok && mode == prepareRun {
+			err := a.guard(module, "PreStart", func() error { return prestarter.PreStart(injector.scope(module)) })
+			if err != nil {
+				return nil, err
 			}
 		}
 	}
-	// Run application.
-	_, err = injector.Call(start.Interface())
-	// Call module Stop(...) methods in reverse.
-	for i := len(a.modules) - 1; i >= 0; i-- {
-		mv := reflect.ValueOf(a.modules[i])
-		method := mv.MethodByName("Stop")
-		if method.IsValid() {
-			// Don't check for errors, as there's not much we can do.
-			injector.Call(method.Interface())
+	// Modules can only be started concurrently if their dependency graph is known.
+	concurrent := true
+	order, err := a.Order()
+	if err != nil && hasOrderingConstraints(a.installed) {
+		return nil, fmt.Errorf("can't satisfy module ordering constraints: %s", err)
+	}
+	if err != nil {
+		a.log().Warnf("%s, falling back to install order", err)
+		order = a.installed
+		concurrent = false
+	}
+	// Modules with no lifecycle methods are valid, but log them to help catch eg. a misspelled Start(...).
+	for _, module := range order {
+		if !hasLifecycle(module) {
+			a.log().Debugf("%T has no lifecycle methods, it only provides types", module)
 		}
 	}
-	return err
+	r.main = main
+	r.start = start
+	r.order = order
+	r.concurrent = concurrent
+	r.reload = &reloadState{args: args, moduleFlags: moduleFlags, modules: append(order[:len(order):len(order)], main)}
+	return r, nil
+}
+
+// Call f with its arguments obtained from the injector, returning its non-error return values.
+//
+// It may only be called while the application module's Start(...) method is running.
+func (a *Application) Call(f interface{}) ([]interface{}, error) {
+	a.lock.Lock()
+	injector := a.injector
+	a.lock.Unlock()
+	if injector == nil {
+		return nil, fmt.Errorf("can't call %T as the application is not running", f)
+	}
+	return injector.Call(f)
 }
@@ -1,8 +1,20 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -42,7 +54,7 @@ func (t *testApp) Start(db DB) error {
 
 func TestAppConfigureProvideInject(t *testing.T) {
 	moduleA := &testModuleA{}
-	app := New("", "").
+	app := New("").
 		Install(moduleA, &testModuleB{})
 
 	myApp := &testApp{}
@@ -60,3 +72,2793 @@ func TestAppConfigureProvideInject(t *testing.T) {
 	assert.Equal(t, "flag", moduleA.Test)
 	assert.Equal(t, DB("DB:postgres://127.0.0.1:flag"), myApp.db)
 }
+
+type testContextApp struct {
+	ctx context.Context
+}
+
+func (t *testContextApp) Start(ctx context.Context) error {
+	t.ctx = ctx
+	return ctx.Err()
+}
+
+func TestAppContextCancelledAfterStart(t *testing.T) {
+	myApp := &testContextApp{}
+	err := New("").RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.NotNil(t, myApp.ctx)
+	assert.Equal(t, context.Canceled, myApp.ctx.Err())
+}
+
+type testSignalApp struct{}
+
+func (t *testSignalApp) Blocking() {}
+
+func (t *testSignalApp) Start(ctx context.Context) error {
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		return err
+	}
+	if err = p.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(time.Second * 5):
+		return fmt.Errorf("context not cancelled by signal")
+	}
+}
+
+func TestAppSignalCancelsContext(t *testing.T) {
+	err := New("").WithSignals(os.Interrupt).RunWithArgs([]string{}, &testSignalApp{})
+	assert.NoError(t, err)
+}
+
+type testStopModule struct {
+	name    string
+	err     error
+	stopped *[]string
+}
+
+func (t *testStopModule) Stop() error {
+	*t.stopped = append(*t.stopped, t.name)
+	return t.err
+}
+
+type testFailingApp struct{ err error }
+
+func (t *testFailingApp) Start() error { return t.err }
+
+func TestAppStopErrorsAreCollected(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(
+		&testStopModule{name: "a", err: fmt.Errorf("a failed"), stopped: &stopped},
+		&testStopModule{name: "b", stopped: &stopped},
+		&testStopModule{name: "c", err: fmt.Errorf("c failed"), stopped: &stopped},
+	)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.Equal(t, []string{"c", "b", "a"}, stopped)
+	assert.EqualError(t, err, "*app.testStopModule.Stop(): c failed; *app.testStopModule.Stop(): a failed")
+
+	stopped = []string{}
+	err = app.RunWithArgs([]string{}, &testFailingApp{err: fmt.Errorf("start failed")})
+	assert.Equal(t, []string{"c", "b", "a"}, stopped)
+	errs, ok := err.(Errors)
+	assert.True(t, ok)
+	assert.Len(t, errs, 3)
+	assert.EqualError(t, errs[0], "*app.testFailingApp.Start(): start failed")
+}
+
+type Cache string
+
+type testCacheModule struct {
+	testStopModule
+}
+
+func (t *testCacheModule) ProvideCache() Cache { return Cache("cache") }
+
+type testHTTPModule struct {
+	testStopModule
+}
+
+func (t *testHTTPModule) Start(cache Cache) error { return nil }
+
+func TestAppStopInReverseDependencyOrder(t *testing.T) {
+	stopped := []string{}
+	http := &testHTTPModule{testStopModule{name: "http", stopped: &stopped}}
+	cache := &testCacheModule{testStopModule{name: "cache", stopped: &stopped}}
+	app := New("").Install(http, cache)
+	order, err := app.Order()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{cache, http}, order)
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http", "cache"}, stopped)
+}
+
+type testPreStartModule struct {
+	testStopModule
+	Flag     string `help:"A flag."`
+	flag     string
+	started  bool
+	startErr error
+}
+
+func (t *testPreStartModule) PreStart(binder Binder) error {
+	t.flag = t.Flag
+	return t.startErr
+}
+
+func (t *testPreStartModule) Start() error {
+	t.started = true
+	return nil
+}
+
+func TestAppPreStart(t *testing.T) {
+	stopped := []string{}
+	module := &testPreStartModule{testStopModule: testStopModule{name: "prestart", stopped: &stopped}}
+	app := New("").Install(module)
+	err := app.RunWithArgs([]string{"--flag=value"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, "value", module.flag)
+	assert.True(t, module.started)
+
+	stopped = []string{}
+	module.started = false
+	module.startErr = fmt.Errorf("invalid flag")
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "invalid flag")
+	assert.False(t, module.started)
+	assert.Empty(t, stopped)
+}
+
+type testSlowModule struct {
+	testStopModule
+}
+
+func (t *testSlowModule) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestAppStartTimeout(t *testing.T) {
+	stopped := []string{}
+	app := New("").
+		StartTimeout(time.Millisecond*10).
+		Install(
+			&testStopModule{name: "fast", stopped: &stopped},
+			&testSlowModule{testStopModule{name: "slow", stopped: &stopped}},
+			&testStopModule{name: "never", stopped: &stopped},
+		)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testSlowModule.Start(): did not complete within 10ms")
+	assert.Equal(t, []string{"fast"}, stopped)
+}
+
+type testFlakyModule struct {
+	failures int
+	attempts int
+}
+
+func (t *testFlakyModule) Start() error {
+	t.attempts++
+	if t.attempts <= t.failures {
+		return fmt.Errorf("attempt %d failed", t.attempts)
+	}
+	return nil
+}
+
+func TestAppStartRetries(t *testing.T) {
+	flaky := &testFlakyModule{failures: 2}
+	app := New("").Install(flaky).StartRetries(flaky, Backoff(3, time.Millisecond, time.Millisecond))
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, flaky.attempts)
+
+	flaky = &testFlakyModule{failures: 5}
+	app = New("").Install(flaky).StartRetries(reflect.TypeOf(flaky), Backoff(3, time.Millisecond, time.Millisecond))
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testFlakyModule.Start(): gave up after 3 attempts: attempt 3 failed")
+	assert.Equal(t, 3, flaky.attempts)
+
+	flaky = &testFlakyModule{failures: 1}
+	err = New("").Install(flaky).RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testFlakyModule.Start(): attempt 1 failed")
+	assert.Equal(t, 1, flaky.attempts)
+
+	policy := Backoff(5, time.Millisecond*10, time.Millisecond*30)
+	delays := []time.Duration{}
+	for attempt := 1; ; attempt++ {
+		delay, ok := policy.Retry(attempt, nil)
+		if !ok {
+			break
+		}
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{
+		time.Millisecond * 10, time.Millisecond * 20, time.Millisecond * 30, time.Millisecond * 30,
+	}, delays)
+}
+
+// testHangingModule counts its attempts to start, each of which blocks until shutdown.
+type testHangingModule struct {
+	attempts int32
+}
+
+func (t *testHangingModule) Start(ctx context.Context) {
+	atomic.AddInt32(&t.attempts, 1)
+	<-ctx.Done()
+}
+
+func TestAppStartRetriesTimeout(t *testing.T) {
+	module := &testHangingModule{}
+	app := New("", WithStartTimeout(time.Millisecond*10)).
+		Install(module).
+		StartRetries(module, Backoff(3, time.Millisecond, time.Millisecond))
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testHangingModule.Start(): did not complete within 10ms")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&module.attempts))
+}
+
+// testFlakyCloserModule eagerly resolves the same io.Closer on each attempt to start.
+type testFlakyCloserModule struct {
+	testFlakyModule
+	closer *testCloser
+}
+
+func (t *testFlakyCloserModule) ProvideCloser() *testCloser { return t.closer }
+
+func (t *testFlakyCloserModule) Eager() []reflect.Type {
+	return []reflect.Type{reflect.TypeOf(&testCloser{})}
+}
+
+func TestAppStartRetriesClosers(t *testing.T) {
+	closed := []string{}
+	module := &testFlakyCloserModule{
+		testFlakyModule: testFlakyModule{failures: 2},
+		closer:          &testCloser{name: "eager", closed: &closed},
+	}
+	app := New("").Install(module).StartRetries(module, Backoff(3, time.Millisecond, time.Millisecond))
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, module.attempts)
+	assert.Equal(t, []string{"eager"}, closed)
+}
+
+type testConcurrentModule struct {
+	testStopModule
+	running *int32
+	peak    *int32
+	err     error
+}
+
+func (t *testConcurrentModule) Start() error {
+	n := atomic.AddInt32(t.running, 1)
+	defer atomic.AddInt32(t.running, -1)
+	for {
+		peak := atomic.LoadInt32(t.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(t.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+	return t.err
+}
+
+func TestAppConcurrentStart(t *testing.T) {
+	var running, peak int32
+	stopped := []string{}
+	modules := []interface{}{}
+	for i := 0; i < 6; i++ {
+		modules = append(modules, &testConcurrentModule{
+			testStopModule: testStopModule{name: fmt.Sprintf("%d", i), stopped: &stopped},
+			running:        &running,
+			peak:           &peak,
+		})
+	}
+	app := New("").Concurrency(3).Install(modules...)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), peak)
+	assert.Len(t, stopped, 6)
+}
+
+type Failing string
+
+type testFailingModule struct {
+	testConcurrentModule
+}
+
+func (t *testFailingModule) ProvideFailing() Failing { return Failing("failing") }
+
+type testDependentModule struct {
+	testStopModule
+}
+
+func (t *testDependentModule) Start(failing Failing) error { return nil }
+
+func TestAppConcurrentStartError(t *testing.T) {
+	var running, peak int32
+	stopped := []string{}
+	failing := &testFailingModule{testConcurrentModule{
+		testStopModule: testStopModule{name: "failing", stopped: &stopped},
+		running:        &running,
+		peak:           &peak,
+		err:            fmt.Errorf("failed"),
+	}}
+	ok := &testConcurrentModule{
+		testStopModule: testStopModule{name: "ok", stopped: &stopped},
+		running:        &running,
+		peak:           &peak,
+	}
+	pending := &testDependentModule{testStopModule{name: "pending", stopped: &stopped}}
+	app := New("").Concurrency(2).Install(pending, failing, ok)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testFailingModule.Start(): failed")
+	assert.Equal(t, []string{"ok"}, stopped)
+	assert.Equal(t, int32(2), peak)
+}
+
+type testPartialStartModule struct {
+	testStopModule
+	start func() error
+}
+
+func (t *testPartialStartModule) Start() error { return t.start() }
+
+func TestAppFailedStartIsNotStopped(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(
+		&testPartialStartModule{testStopModule{name: "a", stopped: &stopped}, func() error { return nil }},
+		&testPartialStartModule{testStopModule{name: "b", stopped: &stopped}, func() error {
+			return fmt.Errorf("partially started")
+		}},
+		&testPartialStartModule{testStopModule{name: "c", stopped: &stopped}, func() error { return nil }},
+	)
+	err := app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "*app.testPartialStartModule.Start(): partially started")
+	assert.Equal(t, []string{"a"}, stopped)
+
+	stopped = []string{}
+	app = New("").Install(
+		&testPartialStartModule{testStopModule{name: "a", stopped: &stopped}, func() error { return nil }},
+		&testPartialStartModule{testStopModule{name: "b", stopped: &stopped}, func() error { panic("nil map") }},
+	)
+	err = app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a"}, stopped)
+}
+
+func TestAppModules(t *testing.T) {
+	moduleA := &testModuleA{}
+	moduleB := &testModuleB{}
+	app := New("").Install(moduleA, moduleB)
+	modules := app.Modules()
+	assert.Equal(t, []interface{}{moduleA, moduleB}, modules)
+	modules[0] = nil
+	assert.Equal(t, []interface{}{moduleA, moduleB}, app.Modules())
+
+	assert.Equal(t, Module{Name: "*app.testModuleA", Module: moduleA}, Describe(moduleA))
+	assert.Equal(t, Module{Name: "*app.testModuleB", Module: moduleB, Configurable: true}, Describe(moduleB))
+	assert.Equal(t, Module{Name: "*app.testHTTPModule", Module: &testHTTPModule{}, Starter: true, Stopper: true},
+		Describe(&testHTTPModule{}))
+}
+
+type testBadStopModule struct{}
+
+func (t *testBadStopModule) Stop() int { return 0 }
+
+func TestAppInvalidLifecycleMethod(t *testing.T) {
+	app := New("").Install(&testBadStopModule{})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testBadStopModule.Stop() must return either nothing or an error, not func() int")
+}
+
+type Server struct{ db DB }
+
+type testServerModule struct{}
+
+func (t *testServerModule) Start(db DB) (*Server, error) { return &Server{db: db}, nil }
+
+type testServerClientModule struct {
+	server *Server
+}
+
+func (t *testServerClientModule) Start(server *Server) error {
+	t.server = server
+	return nil
+}
+
+func TestAppStartReturnValuesAreProvided(t *testing.T) {
+	client := &testServerClientModule{}
+	app := New("").Install(client, &testServerModule{}, &testModuleA{}, &testModuleB{})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, &Server{db: DB("DB:postgres://127.0.0.1:")}, client.server)
+}
+
+type testHealthModule struct {
+	err error
+}
+
+func (t *testHealthModule) HealthCheck(ctx context.Context) error { return t.err }
+
+func TestAppHealth(t *testing.T) {
+	healthy := &testHealthModule{}
+	unhealthy := &testHealthModule{err: fmt.Errorf("database unreachable")}
+	app := New("").Install(healthy, &testModuleA{})
+	assert.NoError(t, app.Health(context.Background()))
+	app.Install(unhealthy)
+	assert.EqualError(t, app.Health(context.Background()), "*app.testHealthModule: database unreachable")
+}
+
+type testProbeModule struct {
+	live  error
+	ready error
+}
+
+func (t *testProbeModule) LivenessCheck(ctx context.Context) error  { return t.live }
+func (t *testProbeModule) ReadinessCheck(ctx context.Context) error { return t.ready }
+
+func TestAppLivenessReadiness(t *testing.T) {
+	probe := &testProbeModule{}
+	app := New("").Install(probe, &testHealthModule{err: fmt.Errorf("unhealthy")})
+	assert.NoError(t, app.Liveness(context.Background()))
+	assert.NoError(t, app.Readiness(context.Background()))
+	probe.ready = fmt.Errorf("database unreachable")
+	assert.NoError(t, app.Liveness(context.Background()))
+	assert.EqualError(t, app.Readiness(context.Background()), "*app.testProbeModule: database unreachable")
+	probe.live = fmt.Errorf("deadlocked")
+	assert.EqualError(t, app.Liveness(context.Background()), "*app.testProbeModule: deadlocked")
+}
+
+type testProbeApp struct {
+	testProbeModule
+	live  error
+	ready error
+}
+
+func (t *testProbeApp) Start(app *Application) {
+	ctx := context.Background()
+	t.live = app.Liveness(ctx)
+	t.ready = app.Readiness(ctx)
+}
+
+func TestAppLivenessReadinessOfRunningModules(t *testing.T) {
+	main := &testProbeApp{testProbeModule: testProbeModule{ready: fmt.Errorf("warming up")}}
+	command := &testProbeModule{live: fmt.Errorf("deadlocked")}
+	app := New("").Install(&testProbeModule{}).CommandModules("serve", command)
+	app.Command("serve", "Serve.")
+	app.Provide(func() *Application { return app })
+	err := app.RunWithArgs([]string{"serve"}, main)
+	assert.NoError(t, err)
+	assert.EqualError(t, main.live, "*app.testProbeModule: deadlocked")
+	assert.EqualError(t, main.ready, "*app.testProbeApp: warming up")
+	assert.NoError(t, app.Readiness(context.Background()))
+}
+
+type testFactoryModule struct {
+	db DB
+}
+
+func TestAppInstallFactory(t *testing.T) {
+	var module *testFactoryModule
+	app := New("").Install(
+		&testModuleA{},
+		&testModuleB{},
+		func(db DB) *testFactoryModule {
+			module = &testFactoryModule{db: db}
+			return module
+		},
+	)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:"), module.db)
+	order, err := app.Order()
+	assert.NoError(t, err)
+	assert.Contains(t, order, module)
+}
+
+type testProfilerModule struct {
+	testStopModule
+	configured bool
+	started    bool
+}
+
+func (t *testProfilerModule) Optional() string { return "profiler" }
+
+func (t *testProfilerModule) Configure(binder Binder) error {
+	t.configured = true
+	return nil
+}
+
+func (t *testProfilerModule) Start() error {
+	t.started = true
+	return nil
+}
+
+func (t *testProfilerModule) ProvideCache() Cache { return Cache("profiler") }
+
+func TestAppOptionalModule(t *testing.T) {
+	stopped := []string{}
+	profiler := &testProfilerModule{testStopModule: testStopModule{name: "profiler", stopped: &stopped}}
+	err := New("").Install(profiler).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.False(t, profiler.configured)
+	assert.False(t, profiler.started)
+	assert.Empty(t, stopped)
+
+	err = New("").Install(profiler).RunWithArgs([]string{"--enable-profiler"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.True(t, profiler.configured)
+	assert.True(t, profiler.started)
+	assert.Equal(t, []string{"profiler"}, stopped)
+}
+
+func TestAppOnEvent(t *testing.T) {
+	events := []string{}
+	stopped := []string{}
+	app := New("").
+		OnEvent(func(event Event) {
+			events = append(events, fmt.Sprintf("%s %s %v", event.Module, event.Type, event.Err))
+		}).
+		Install(
+			&testModuleB{},
+			&testHTTPModule{testStopModule{name: "http", stopped: &stopped, err: fmt.Errorf("failed")}},
+			&testCacheModule{testStopModule{name: "cache", stopped: &stopped}},
+		)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.Error(t, err)
+	assert.Equal(t, []string{
+		"*app.testModuleB installed <nil>",
+		"*app.testModuleB configured <nil>",
+		"*app.testHTTPModule installed <nil>",
+		"*app.testCacheModule installed <nil>",
+		"*app.testFailingApp installed <nil>",
+		"*app.testHTTPModule started <nil>",
+		"*app.testHTTPModule errored *app.testHTTPModule.Stop(): failed",
+		"*app.testCacheModule stopped <nil>",
+	}, events)
+}
+
+func TestAppEvents(t *testing.T) {
+	app := New("").Install(&testModuleB{})
+	events := app.Events()
+	assert.Equal(t, events, app.Events())
+	err := app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.NoError(t, err)
+	received := []string{}
+	for len(events) > 0 {
+		event := <-events
+		received = append(received, fmt.Sprintf("%s %s", event.Module, event.Type))
+	}
+	assert.Equal(t, []string{
+		"*app.testModuleB installed",
+		"*app.testModuleB configured",
+		"*app.testNoArgsApp installed",
+	}, received)
+
+	// Events are dropped rather than blocking once the buffer is full.
+	for i := 0; i < eventBuffer; i++ {
+		err = app.RunWithArgs([]string{}, &testNoArgsApp{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, eventBuffer, len(events))
+}
+
+type testRestartModule struct {
+	testStopModule
+	configured int
+	started    int
+}
+
+func (t *testRestartModule) Configure(binder Binder) error {
+	t.configured++
+	return nil
+}
+
+func (t *testRestartModule) Start() error {
+	t.started++
+	return nil
+}
+
+type testRestartApp struct {
+	app *Application
+}
+
+func (t *testRestartApp) Start() error {
+	return t.app.RestartModule("*app.testRestartModule")
+}
+
+func TestAppRestartModule(t *testing.T) {
+	stopped := []string{}
+	module := &testRestartModule{testStopModule: testStopModule{name: "restart", stopped: &stopped}}
+	app := New("").Install(module)
+	err := app.RestartModule("*app.testRestartModule")
+	assert.EqualError(t, err, "can't restart *app.testRestartModule as the application is not running")
+	err = app.RunWithArgs([]string{}, &testRestartApp{app: app})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, module.configured)
+	assert.Equal(t, 2, module.started)
+	assert.Equal(t, []string{"restart", "restart"}, stopped)
+}
+
+// testCallingRestartModule calls back into the Application while restarting.
+type testCallingRestartModule struct {
+	app     *Application
+	started int
+}
+
+func (t *testCallingRestartModule) Start() error {
+	t.started++
+	if t.started == 1 {
+		return nil
+	}
+	_, err := t.app.Call(func() {})
+	return err
+}
+
+type testCallingRestartApp struct {
+	app *Application
+}
+
+func (t *testCallingRestartApp) Start() error {
+	return t.app.RestartModule("*app.testCallingRestartModule")
+}
+
+func TestAppRestartModuleCallsApplication(t *testing.T) {
+	app := New("")
+	module := &testCallingRestartModule{app: app}
+	err := app.Install(module).RunWithArgs([]string{}, &testCallingRestartApp{app: app})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, module.started)
+}
+
+func TestAppValidate(t *testing.T) {
+	app := New("").Install(&testModuleA{})
+	err := app.Validate()
+	assert.EqualError(t, err, "*app.testModuleA.ProvideDB() requires app.DBURI, which is not bound by any module")
+	err = app.RunWithArgs([]string{}, &testApp{})
+	assert.EqualError(t, err, "*app.testModuleA.ProvideDB() requires app.DBURI, which is not bound by any module")
+
+	app.Install(&testModuleB{})
+	assert.NoError(t, app.Validate())
+}
+
+type testCycleModuleA struct{}
+
+func (t *testCycleModuleA) ProvideDB(uri DBURI) DB { return DB(uri) }
+
+type testCycleModuleB struct{}
+
+func (t *testCycleModuleB) ProvideURI(db DB) DBURI { return DBURI(db) }
+
+func TestAppValidateCycle(t *testing.T) {
+	app := New("").Install(&testCycleModuleA{}, &testCycleModuleB{})
+	err := app.Validate()
+	assert.EqualError(t, err, "dependency cycle: "+
+		"app.DB (*app.testCycleModuleA.ProvideDB()) -> "+
+		"app.DBURI (*app.testCycleModuleB.ProvideURI()) -> "+
+		"app.DB")
+}
+
+type testCommandModule struct {
+	Force   bool `help:"Force."`
+	started bool
+	force   bool
+	db      DB
+}
+
+func (t *testCommandModule) Start(db DB) error {
+	t.started = true
+	t.force = t.Force
+	t.db = db
+	return nil
+}
+
+func TestAppMainCommand(t *testing.T) {
+	serve := &testCommandModule{}
+	migrate := &testCommandModule{}
+	app := New("").Install(&testModuleA{}, &testModuleB{})
+	app.MainCommand("serve", "Serve.", serve)
+	app.MainCommand("migrate", "Migrate.", migrate)
+	err := app.RunWithArgs([]string{"migrate", "--force"}, nil)
+	assert.NoError(t, err)
+	assert.False(t, serve.started)
+	assert.True(t, migrate.started)
+	assert.True(t, migrate.force)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:"), migrate.db)
+
+	err = app.RunWithArgs([]string{}, nil)
+	assert.EqualError(t, err, `no application module for command ""`)
+}
+
+type testLifecycleApp struct {
+	command SelectedCommand
+}
+
+func (t *testLifecycleApp) Blocking() {}
+
+func (t *testLifecycleApp) Start(ctx context.Context, lifecycle Lifecycle) error {
+	t.command = lifecycle.Command()
+	lifecycle.Shutdown()
+	<-ctx.Done()
+	return nil
+}
+
+func TestAppLifecycle(t *testing.T) {
+	app := New("")
+	app.Command("serve", "Serve.")
+	myApp := &testLifecycleApp{}
+	err := app.RunWithArgs([]string{"serve"}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, SelectedCommand("serve"), myApp.command)
+}
+
+type testShutdownHookModule struct {
+	testStopModule
+}
+
+func (t *testShutdownHookModule) Start(lifecycle Lifecycle, cache Cache) error {
+	lifecycle.OnShutdown(func() error {
+		*t.stopped = append(*t.stopped, "hook")
+		return nil
+	})
+	return nil
+}
+
+func TestAppOnShutdown(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(
+		&testShutdownHookModule{testStopModule{name: "hooked", stopped: &stopped}},
+		&testCacheModule{testStopModule{name: "cache", stopped: &stopped}},
+	)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hooked", "hook", "cache"}, stopped)
+}
+
+type testEnvarModule struct {
+	HTTPBind string `help:"Bind address."`
+	Explicit string `help:"Explicit." envar:"EXPLICIT"`
+}
+
+func TestAppEnvarPrefix(t *testing.T) {
+	os.Setenv("MYAPP_HTTP_BIND", ":8080")
+	os.Setenv("EXPLICIT", "explicit")
+	os.Setenv("MYAPP_EXPLICIT", "wrong")
+	defer os.Unsetenv("MYAPP_HTTP_BIND")
+	defer os.Unsetenv("EXPLICIT")
+	defer os.Unsetenv("MYAPP_EXPLICIT")
+	module := &testEnvarModule{}
+	err := New("myapp").EnvarPrefix("myapp").Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":8080", module.HTTPBind)
+	assert.Equal(t, "explicit", module.Explicit)
+}
+
+type testConfigModule struct {
+	HTTPBind string `help:"Bind address." default:":80"`
+	Debug    bool   `help:"Debug."`
+}
+
+func (t *testConfigModule) ModuleName() string { return "http" }
+
+func TestAppConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte("http:\n  http-bind: \":8080\"\n  debug: true\n"), 0600)
+	assert.NoError(t, err)
+
+	module := &testConfigModule{}
+	err = New("").ConfigFile(path).Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":8080", module.HTTPBind)
+	assert.True(t, module.Debug)
+
+	module = &testConfigModule{}
+	app := New("").ConfigFile("").Install(module)
+	err = app.RunWithArgs([]string{"--config", path, "--http-bind=:9090"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", module.HTTPBind)
+	assert.True(t, module.Debug)
+}
+
+type testConfigSource struct{ bind string }
+
+func (t *testConfigSource) Load(module interface{}) error {
+	if module, ok := module.(*testConfigModule); ok {
+		if t.bind == "" {
+			return fmt.Errorf("unavailable")
+		}
+		module.HTTPBind = t.bind
+	}
+	return nil
+}
+
+func TestAppConfigSources(t *testing.T) {
+	module := &testConfigModule{}
+	app := New("").Install(module).
+		AddConfigSource(&testConfigSource{bind: ":8080"}).
+		AddConfigSource(&testConfigSource{bind: ":9090"})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", module.HTTPBind)
+	assert.False(t, module.Debug)
+
+	err = app.RunWithArgs([]string{"--http-bind=:7070"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":7070", module.HTTPBind)
+
+	err = New("").Install(&testConfigModule{}).AddConfigSource(&testConfigSource{}).
+		RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testConfigSource.Load(*app.testConfigModule): unavailable")
+}
+
+func TestAppPresetFields(t *testing.T) {
+	module := &testConfigModule{HTTPBind: ":0"}
+	err := New("").PresetFields(true).Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":0", module.HTTPBind)
+	assert.False(t, module.Debug)
+
+	module = &testConfigModule{HTTPBind: ":0"}
+	err = New("").PresetFields(true).Install(module).RunWithArgs([]string{"--http-bind=:9090"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", module.HTTPBind)
+
+	module = &testConfigModule{HTTPBind: ":0"}
+	err = New("").PresetFields(true).Install(module).AddConfigSource(&testConfigSource{bind: ":8080"}).
+		RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":0", module.HTTPBind)
+
+	module = &testConfigModule{HTTPBind: ":0"}
+	err = New("").Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":80", module.HTTPBind)
+}
+
+func TestAppSetFlag(t *testing.T) {
+	module := &testConfigModule{}
+	err := New("").SetFlag("http-bind", ":0").SetFlag("debug", "true").Install(module).
+		RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":0", module.HTTPBind)
+	assert.True(t, module.Debug)
+
+	module = &testConfigModule{}
+	err = New("").SetFlag("http-bind", ":0").Install(module).
+		RunWithArgs([]string{"--http-bind=:9090"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", module.HTTPBind)
+
+	err = New("").SetFlag("http-port", "80").Install(&testConfigModule{}).RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "can't set unknown flag --http-port")
+}
+
+func TestAppDumpConfig(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	app := New("").Terminate(nil).SetFlag("http-bind", ":8080").Install(&testConfigModule{})
+	app.Writers(stdout, ioutil.Discard)
+	err := app.RunWithArgs([]string{"--config-dump", "--debug"}, &testFailingApp{})
+	assert.Equal(t, TerminatedError{Status: 0}, err)
+	assert.Equal(t, "http:\n  debug: true\n  http-bind: :8080\n", stdout.String())
+
+	stdout.Reset()
+	err = app.DumpConfig(stdout, "json")
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"http\": {\n    \"debug\": true,\n    \"http-bind\": \":8080\"\n  }\n}\n", stdout.String())
+
+	err = app.DumpConfig(stdout, "toml")
+	assert.EqualError(t, err, `unsupported configuration format "toml"`)
+}
+
+// Credentials is exported, as kingpin ignores unexported fields, including embedded structs.
+type Credentials struct {
+	Token string `help:"Token." secret:"true"`
+}
+
+type testSecretModule struct {
+	Credentials
+	DBUser     string `help:"Database user." default:"app"`
+	DBPassword string `help:"Database password." secret:"true"`
+	APIKey     string `help:"API key." long:"key" secret:"true"`
+}
+
+func TestAppSecretRedaction(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	module := &testSecretModule{}
+	app := New("").Terminate(nil).Install(module)
+	app.Writers(stdout, ioutil.Discard)
+	args := []string{"--config-dump", "--db-password=hunter2", "--key=abc123", "--token=xyzzy"}
+	err := app.RunWithArgs(args, &testFailingApp{})
+	assert.Equal(t, TerminatedError{Status: 0}, err)
+	assert.Equal(t, "hunter2", module.DBPassword)
+	assert.Equal(t, "xyzzy", module.Token)
+	assert.Equal(t, "testsecretmodule:\n  db-password: '****'\n  db-user: app\n  key: '****'\n  token: '****'\n",
+		stdout.String())
+	assert.NotContains(t, stdout.String(), "hunter2")
+	assert.NotContains(t, stdout.String(), "abc123")
+	assert.NotContains(t, stdout.String(), "xyzzy")
+}
+
+func TestAppRepeatedRuns(t *testing.T) {
+	for _, namespace := range []bool{false, true} {
+		modules := []*testConfigModule{}
+		app := New("").NamespaceFlags(namespace).Install(func() *testConfigModule {
+			module := &testConfigModule{}
+			modules = append(modules, module)
+			return module
+		})
+		bind := "--http-bind=:8080"
+		if namespace {
+			bind = "--http.http-bind=:8080"
+		}
+		err := app.RunWithArgs([]string{bind}, &testFailingApp{})
+		assert.NoError(t, err)
+		flags := len(app.Model().Flags)
+
+		err = app.RunWithArgs([]string{}, &testFailingApp{})
+		assert.NoError(t, err)
+		assert.Equal(t, flags, len(app.Model().Flags))
+		assert.Equal(t, 2, len(modules))
+		assert.Equal(t, ":8080", modules[0].HTTPBind)
+		assert.Equal(t, ":80", modules[1].HTTPBind)
+	}
+}
+
+func TestAppConfigProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	config := "http:\n  http-bind: \":8080\"\nprofiles:\n  prod:\n    http:\n      http-bind: \":80\"\n      debug: true\n"
+	err = ioutil.WriteFile(path, []byte(config), 0600)
+	assert.NoError(t, err)
+
+	module := &testConfigModule{}
+	err = New("").ConfigFile(path).Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":8080", module.HTTPBind)
+	assert.False(t, module.Debug)
+
+	module = &testConfigModule{}
+	err = New("").ConfigFile(path).Install(module).RunWithArgs([]string{"--profile=prod"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":80", module.HTTPBind)
+	assert.True(t, module.Debug)
+
+	// The command-line takes precedence over the profile.
+	module = &testConfigModule{}
+	os.Setenv("MYAPP_PROFILE", "prod")
+	defer os.Unsetenv("MYAPP_PROFILE")
+	err = New("myapp").ConfigFile(path).Install(module).RunWithArgs([]string{"--http-bind=:9090"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", module.HTTPBind)
+	assert.True(t, module.Debug)
+
+	// The environment variable is named after the application.
+	module = &testConfigModule{}
+	err = New("other").ConfigFile(path).Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.False(t, module.Debug)
+
+	// Calling ConfigFile() again replaces the path, rather than adding the flags again.
+	module = &testConfigModule{}
+	app := New("").ConfigFile("missing.yaml").ConfigFile(path).Install(module)
+	err = app.RunWithArgs([]string{"--profile=prod"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, ":80", module.HTTPBind)
+	configFlags := 0
+	for _, flag := range app.Model().Flags {
+		if flag.Name == "config" || flag.Name == "profile" {
+			configFlags++
+		}
+	}
+	assert.Equal(t, 2, configFlags)
+
+	err = New("").ConfigFile(path).Install(&testConfigModule{}).RunWithArgs([]string{"--profile=dev"}, &testFailingApp{})
+	assert.EqualError(t, err, path+`: unknown profile "dev"`)
+}
+
+type testRunnerModule struct {
+	cancelled bool
+}
+
+func (t *testRunnerModule) ProvideRunnerSequence() []Runner {
+	return []Runner{
+		func(ctx context.Context) error {
+			<-ctx.Done()
+			t.cancelled = true
+			return ctx.Err()
+		},
+		func(ctx context.Context) error { return fmt.Errorf("failed") },
+	}
+}
+
+func TestAppRunners(t *testing.T) {
+	module := &testRunnerModule{}
+	err := New("").Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "failed")
+	assert.True(t, module.cancelled)
+}
+
+func TestSelectedCommand(t *testing.T) {
+	command := SelectedCommand("db migrate")
+	assert.Equal(t, []string{"db", "migrate"}, command.Path())
+	assert.True(t, command.Is("db", "migrate"))
+	assert.False(t, command.Is("db"))
+	assert.False(t, command.Is("db", "dump"))
+	assert.True(t, SelectedCommand("").Is())
+}
+
+type testMetaModule struct{}
+
+func (t *testMetaModule) Configure(binder Binder) error {
+	return binder.Install(&testModuleA{}, &testModuleB{}, &testModuleB{})
+}
+
+func TestAppConfigureInstallsModules(t *testing.T) {
+	app := New("").Install(&testMetaModule{})
+	myApp := &testApp{}
+	err := app.RunWithArgs([]string{"--test=flag"}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:flag"), myApp.db)
+	assert.NoError(t, app.Validate())
+}
+
+type testCountingModule struct {
+	Count  string `help:"A flag that would be duplicated."`
+	starts *int
+}
+
+func (t *testCountingModule) Start()     { *t.starts++ }
+func (t *testCountingModule) Singleton() {}
+
+func TestAppDeduplicatesModules(t *testing.T) {
+	starts := 0
+	first := &testCountingModule{starts: &starts}
+	second := &testCountingModule{starts: &starts}
+	moduleA := &testModuleA{}
+	app := New("").Install(first, moduleA, &testMetaModule{}, second, moduleA)
+	err := app.RunWithArgs([]string{"--test=flag"}, &testApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, starts)
+	assert.Equal(t, []interface{}{first, moduleA, &testMetaModule{}, &testModuleB{}}, app.installed)
+}
+
+type testPanicModule struct{}
+
+func (t *testPanicModule) Start() { panic("boom") }
+
+func TestAppRecoversPanics(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(&testStopModule{name: "a", stopped: &stopped}, &testPanicModule{})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "*app.testPanicModule.Start() panicked: boom\n")
+	assert.Contains(t, err.Error(), "goroutine")
+	assert.Equal(t, []string{"a"}, stopped)
+
+	app = New("").Install(&testPanicModule{}).RecoverPanics(false)
+	assert.PanicsWithValue(t, "boom", func() { _ = app.RunWithArgs([]string{}, &testFailingApp{}) })
+}
+
+type testPanicHookApp struct{}
+
+func (t *testPanicHookApp) Start(lifecycle Lifecycle) {
+	lifecycle.OnShutdown(func() error { panic("boom") })
+}
+
+func TestAppRecoversShutdownHookPanics(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(&testStopModule{name: "a", stopped: &stopped})
+	err := app.RunWithArgs([]string{}, &testPanicHookApp{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "app.shutdownHook.OnShutdown() panicked: boom\n")
+	assert.Equal(t, []string{"a"}, stopped)
+}
+
+type testBuildInfoApp struct{ info BuildInfo }
+
+func (t *testBuildInfoApp) Start(info BuildInfo) { t.info = info }
+
+type testAppInfoApp struct{ info AppInfo }
+
+func (t *testAppInfoApp) Start(info AppInfo) { t.info = info }
+
+func TestAppInfo(t *testing.T) {
+	myApp := &testAppInfoApp{}
+	err := New("myapp", WithHelp("My app.")).RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, AppInfo{Name: "myapp", Help: "My app."}, myApp.info)
+}
+
+func TestAppBuildInfo(t *testing.T) {
+	date := time.Date(2018, 8, 10, 21, 56, 34, 0, time.UTC)
+	app := New("").Version("1.2.3").WithBuildInfo(BuildInfo{Commit: "df19058", Date: date})
+	myApp := &testBuildInfoApp{}
+	err := app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, BuildInfo{Version: "1.2.3", Commit: "df19058", Date: date}, myApp.info)
+}
+
+type testLoggingModule struct {
+	testStopModule
+	before []reflect.Type
+}
+
+func (t *testLoggingModule) Before() []reflect.Type { return t.before }
+
+func TestAppOrderingConstraints(t *testing.T) {
+	stopped := []string{}
+	http := &testHTTPModule{testStopModule{name: "http", stopped: &stopped}}
+	cache := &testCacheModule{testStopModule{name: "cache", stopped: &stopped}}
+	logging := &testLoggingModule{
+		testStopModule: testStopModule{name: "logging", stopped: &stopped},
+		before:         []reflect.Type{reflect.TypeOf(cache)},
+	}
+	app := New("").Install(http, cache, logging)
+	order, err := app.Order()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{logging, cache, http}, order)
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http", "cache", "logging"}, stopped)
+
+	app = New("").Install(&testContradictoryModule{}, cache, logging)
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "can't satisfy module ordering constraints: dependency cycle between modules "+
+		"*app.testContradictoryModule, *app.testCacheModule, *app.testLoggingModule")
+}
+
+type testDependsOnModule struct {
+	testStopModule
+	dependsOn []interface{}
+}
+
+func (t *testDependsOnModule) DependsOn() []interface{} { return t.dependsOn }
+
+func TestAppDependsOn(t *testing.T) {
+	stopped := []string{}
+	migrate := &testStopModule{name: "migrate", stopped: &stopped}
+	worker := &testDependsOnModule{
+		testStopModule: testStopModule{name: "worker", stopped: &stopped},
+		dependsOn:      []interface{}{migrate},
+	}
+	app := New("").Install(worker, migrate)
+	order, err := app.Order()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{migrate, worker}, order)
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker", "migrate"}, stopped)
+
+	worker.dependsOn = []interface{}{reflect.TypeOf(migrate)}
+	order, err = New("").Install(worker, migrate).Order()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{migrate, worker}, order)
+
+	worker.dependsOn = []interface{}{migrate}
+	err = New("").Install(worker).RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "can't satisfy module ordering constraints: *app.testDependsOnModule depends on "+
+		"*app.testStopModule, but it is not installed")
+}
+
+type testContradictoryModule struct{}
+
+func (t *testContradictoryModule) After() []reflect.Type {
+	return []reflect.Type{reflect.TypeOf(&testCacheModule{})}
+}
+
+func (t *testContradictoryModule) Before() []reflect.Type {
+	return []reflect.Type{reflect.TypeOf(&testLoggingModule{})}
+}
+
+type testValidatorModule struct {
+	Bind string `help:"Bind address."`
+}
+
+func (t *testValidatorModule) Validate() error {
+	if !strings.Contains(t.Bind, ":") {
+		return fmt.Errorf("invalid bind address %q", t.Bind)
+	}
+	return nil
+}
+
+type testValidatorApp struct {
+	testFailingApp
+	Workers int `help:"Number of workers."`
+}
+
+func (t *testValidatorApp) Validate() error {
+	if t.Workers < 1 {
+		return fmt.Errorf("at least one worker is required")
+	}
+	return nil
+}
+
+func TestAppValidator(t *testing.T) {
+	app := New("").Install(&testValidatorModule{})
+	err := app.RunWithArgs([]string{"--bind=localhost"}, &testValidatorApp{})
+	assert.EqualError(t, err, `*app.testValidatorModule: invalid bind address "localhost"; `+
+		`*app.testValidatorApp: at least one worker is required`)
+
+	app = New("").Install(&testValidatorModule{})
+	err = app.RunWithArgs([]string{"--bind=:8080", "--workers=2"}, &testValidatorApp{})
+	assert.NoError(t, err)
+}
+
+func TestAppConfigureOnly(t *testing.T) {
+	app := New("").Install(&testValidatorModule{})
+	err := app.ConfigureOnly([]string{"--bind=localhost"}, &testValidatorApp{})
+	assert.EqualError(t, err, `*app.testValidatorModule: invalid bind address "localhost"; `+
+		`*app.testValidatorApp: at least one worker is required`)
+
+	stopped := []string{}
+	prestart := &testPreStartModule{testStopModule: testStopModule{name: "prestart", stopped: &stopped}}
+	myApp := &testApp{}
+	app = New("").Install(&testValidatorModule{}, prestart, &testModuleA{}, &testModuleB{})
+	err = app.ConfigureOnly([]string{"--bind=:8080", "--flag=value"}, myApp)
+	assert.NoError(t, err)
+	assert.True(t, myApp.configured)
+	assert.Equal(t, "value", prestart.Flag)
+	assert.Equal(t, "", prestart.flag)
+	assert.False(t, prestart.started)
+	assert.Equal(t, 0, myApp.run)
+	assert.Empty(t, stopped)
+}
+
+func TestAppCommandModel(t *testing.T) {
+	migrate := &testCommandModule{}
+	app := New("").Install(&testConfigModule{}).SetFlag("http-bind", ":8080")
+	app.MainCommand("migrate", "Migrate.", migrate)
+	model, err := app.CommandModel(nil)
+	assert.NoError(t, err)
+	flags := map[string]string{}
+	for _, flag := range model.Flags {
+		flags[flag.Name] = strings.Join(flag.Default, ",") + " " + flag.Help
+	}
+	assert.Equal(t, ":8080 Bind address.", flags["http-bind"])
+	assert.Equal(t, " Debug.", flags["debug"])
+	assert.Len(t, model.Commands, 1)
+	assert.Equal(t, "migrate", model.Commands[0].Name)
+	assert.Equal(t, "Migrate.", model.Commands[0].Help)
+	assert.Equal(t, "force", model.Commands[0].Flags[0].Name)
+	assert.False(t, migrate.started)
+
+	_, err = New("").CommandModel(nil)
+	assert.EqualError(t, err, "no application module")
+}
+
+type testBackendModule struct {
+	Backend string `help:"Database backend." default:"memory"`
+}
+
+func (t *testBackendModule) PostParse(binder Binder) error {
+	switch t.Backend {
+	case "memory":
+		return binder.Bind(DB("memory"))
+	case "postgres":
+		return binder.Provide(func() DB { return DB("postgres") })
+	}
+	return fmt.Errorf("unknown backend %q", t.Backend)
+}
+
+func TestAppPostParse(t *testing.T) {
+	myApp := &testApp{}
+	app := New("").Install(&testBackendModule{})
+	assert.NoError(t, app.Validate())
+	err := app.RunWithArgs([]string{"--backend=postgres"}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("postgres"), myApp.db)
+
+	app = New("").Install(&testBackendModule{})
+	err = app.RunWithArgs([]string{"--backend=mysql"}, myApp)
+	assert.EqualError(t, err, `unknown backend "mysql"`)
+}
+
+type testSlowStopModule struct {
+	release chan struct{}
+}
+
+func (t *testSlowStopModule) Stop() { <-t.release }
+
+func TestAppShutdownTimeout(t *testing.T) {
+	stopped := []string{}
+	slow := &testSlowStopModule{release: make(chan struct{})}
+	defer close(slow.release)
+	app := New("").
+		ShutdownTimeout(10*time.Millisecond).
+		Install(&testStopModule{name: "a", stopped: &stopped}, slow, &testStopModule{name: "c", stopped: &stopped})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "shutdown did not complete within 10ms, still stopping *app.testSlowStopModule, "+
+		"*app.testStopModule")
+	assert.Equal(t, []string{"c"}, stopped)
+}
+
+type testLogger struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+func (t *testLogger) logf(level, format string, args ...interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lines = append(t.lines, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (t *testLogger) Debugf(format string, args ...interface{}) { t.logf("debug", format, args...) }
+func (t *testLogger) Infof(format string, args ...interface{})  { t.logf("info", format, args...) }
+func (t *testLogger) Warnf(format string, args ...interface{})  { t.logf("warn", format, args...) }
+func (t *testLogger) Errorf(format string, args ...interface{}) { t.logf("error", format, args...) }
+
+type testLoggerModule struct{ logger *testLogger }
+
+func (t *testLoggerModule) ProvideLogger() Logger { return t.logger }
+
+type testMisnamedModule struct{}
+
+func (t *testMisnamedModule) PreSTART() {}
+
+type testLoggingApp struct{}
+
+func (t *testLoggingApp) Start(logger Logger) { logger.Infof("hello") }
+
+func TestAppLogger(t *testing.T) {
+	logger := &testLogger{}
+	app := New("").Logger(logger).Install(&testMisnamedModule{})
+	err := app.RunWithArgs([]string{}, &testLoggingApp{})
+	assert.NoError(t, err)
+	assert.Contains(t, logger.lines,
+		"warn: *app.testMisnamedModule.PreSTART() will not be called, did you mean PreStart()?")
+	assert.Contains(t, logger.lines, "info: hello")
+
+	provided := &testLogger{}
+	app = New("").Logger(logger).Install(&testLoggerModule{provided}, &testStopModule{stopped: &[]string{}})
+	err = app.RunWithArgs([]string{}, &testLoggingApp{})
+	assert.NoError(t, err)
+	assert.Contains(t, provided.lines, "info: hello")
+	assert.Contains(t, provided.lines[len(provided.lines)-1], "debug: *app.testStopModule stopped in ")
+}
+
+func TestAppTerminate(t *testing.T) {
+	myApp := &testApp{}
+	app := New("").Terminate(nil).Install(&testModuleA{}, &testModuleB{})
+	app.Writers(ioutil.Discard, ioutil.Discard)
+	err := app.RunWithArgs([]string{"--help"}, myApp)
+	assert.Equal(t, TerminatedError{Status: 0}, err)
+	assert.Equal(t, 0, myApp.run)
+
+	status := -1
+	app = New("").Terminate(func(s int) { status = s }).Install(&testModuleA{}, &testModuleB{})
+	app.Writers(ioutil.Discard, ioutil.Discard)
+	err = app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	app.Fatalf("failed")
+	assert.Equal(t, 1, status)
+}
+
+func TestAppFatalIfError(t *testing.T) {
+	status := -1
+	stderr := &bytes.Buffer{}
+	app := New("myapp").Terminate(func(s int) { status = s })
+	app.Writers(ioutil.Discard, stderr)
+
+	app.FatalIfError(nil, "")
+	assert.Equal(t, -1, status)
+	assert.Empty(t, stderr.String())
+
+	app.FatalIfError(fmt.Errorf("100%% failed"), "")
+	assert.Equal(t, 1, status)
+	assert.Equal(t, "myapp: error: 100% failed\n", stderr.String())
+
+	stderr.Reset()
+	app.FatalIfError(fmt.Errorf("connection refused"), "failed to connect to %s", "db")
+	assert.Equal(t, "myapp: error: failed to connect to db: connection refused\n", stderr.String())
+}
+
+type testFailingConfigureModule struct{}
+
+func (t *testFailingConfigureModule) Configure(binder Binder) error { return fmt.Errorf("bad config") }
+
+func TestAppTypedErrors(t *testing.T) {
+	err := New("").RunWithArgs(nil, &testStopModule{})
+	assert.True(t, errors.Is(err, ErrNoStartMethod))
+	assert.EqualError(t, err, "no Start(...) method on application module")
+
+	module := &testFailingConfigureModule{}
+	err = New("").Install(module).RunWithArgs(nil, &testFailingApp{})
+	var configureErr ConfigureError
+	assert.True(t, errors.As(err, &configureErr))
+	assert.Equal(t, module, configureErr.Module)
+	assert.EqualError(t, err, "*app.testFailingConfigureModule.Configure(): bad config")
+
+	cause := fmt.Errorf("start failed")
+	main := &testFailingApp{err: cause}
+	err = New("").RunWithArgs(nil, main)
+	var startErr StartError
+	assert.True(t, errors.As(err, &startErr))
+	assert.Equal(t, main, startErr.Module)
+	assert.True(t, errors.Is(err, cause))
+	assert.EqualError(t, err, "*app.testFailingApp.Start(): start failed")
+
+	// Errors are matched through Errors, eg. when stopping also fails.
+	stopped := []string{}
+	stopModule := &testStopModule{name: "stop", err: fmt.Errorf("stop failed"), stopped: &stopped}
+	err = New("").Install(stopModule).RunWithArgs(nil, main)
+	assert.EqualError(t, err, "*app.testFailingApp.Start(): start failed; *app.testStopModule.Stop(): stop failed")
+	startErr = StartError{}
+	assert.True(t, errors.As(err, &startErr))
+	assert.Equal(t, main, startErr.Module)
+	assert.True(t, errors.Is(err, cause))
+
+	err = New("").Terminate(nil).RunWithArgs([]string{"--unknown"}, &testFailingApp{})
+	var parseErr ParseError
+	assert.True(t, errors.As(err, &parseErr))
+}
+
+func TestAppOnExit(t *testing.T) {
+	calls := []string{}
+	app := New("test").Terminate(func(status int) { calls = append(calls, fmt.Sprintf("terminate %d", status)) })
+	app.Writers(ioutil.Discard, ioutil.Discard)
+	app.OnExit(func() { calls = append(calls, "flush logs") })
+	app.OnExit(func() { calls = append(calls, "flush traces") })
+
+	err := app.RunWithArgs(nil, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+
+	app.FatalIfError(fmt.Errorf("failed"), "")
+	assert.Equal(t, []string{"flush traces", "flush logs", "terminate 1"}, calls)
+}
+
+type testRawArgsApp struct {
+	Verbose bool `help:"Verbose."`
+	raw     RawArgs
+	args    Args
+}
+
+func (t *testRawArgsApp) Start(raw RawArgs, args Args) {
+	t.raw = raw
+	t.args = args
+}
+
+func TestAppRawArgs(t *testing.T) {
+	main := &testRawArgsApp{}
+	err := New("test").PositionalArgs("arg", "Arguments.").RunWithArgs([]string{"--verbose", "file"}, main)
+	assert.NoError(t, err)
+	assert.Equal(t, RawArgs{"--verbose", "file"}, main.raw)
+	assert.Equal(t, Args{"file"}, main.args)
+}
+
+func TestAppExitError(t *testing.T) {
+	err := New("test").RunWithArgs(nil, &testFailingApp{err: ExitError{Code: 3}})
+	exit, ok := exitError(err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, exit.Code)
+	assert.EqualError(t, exit, "exit status 3")
+
+	exit, ok = exitError(Errors{fmt.Errorf("stop failed"), ExitError{Code: 2, Err: fmt.Errorf("bad input")}})
+	assert.True(t, ok)
+	assert.Equal(t, 2, exit.Code)
+	assert.EqualError(t, exit, "bad input")
+
+	_, ok = exitError(fmt.Errorf("failed"))
+	assert.False(t, ok)
+}
+
+type testScopedModule struct {
+	uri DBURI
+}
+
+func (t *testScopedModule) Configure(binder Binder) error {
+	return binder.Bind(t.uri)
+}
+
+func (t *testScopedModule) ProvideDB(uri DBURI) DB { return DB("DB:" + uri) }
+
+func (t *testScopedModule) Exports() []reflect.Type { return []reflect.Type{reflect.TypeOf(DB(""))} }
+
+type testScopedConsumerModule struct {
+	testScopedModule
+	db DB
+}
+
+func (t *testScopedConsumerModule) ProvideCache(db DB) Cache { return Cache("Cache:" + db) }
+
+func (t *testScopedConsumerModule) Exports() []reflect.Type {
+	return []reflect.Type{reflect.TypeOf(Cache(""))}
+}
+
+func (t *testScopedConsumerModule) Start(db DB) { t.db = db }
+
+type testScopedApp struct {
+	db    DB
+	cache Cache
+}
+
+func (t *testScopedApp) Start(db DB, cache Cache) {
+	t.db = db
+	t.cache = cache
+}
+
+func TestAppScopedModules(t *testing.T) {
+	consumer := &testScopedConsumerModule{testScopedModule: testScopedModule{uri: "sqlite://"}}
+	app := New("").Install(&testScopedModule{uri: "postgres://"}, consumer)
+	assert.NoError(t, app.Validate())
+	myApp := &testScopedApp{}
+	err := app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://"), myApp.db)
+	assert.Equal(t, Cache("Cache:DB:sqlite://"), myApp.cache)
+	assert.Equal(t, DB("DB:sqlite://"), consumer.db)
+
+	// Private bindings are not visible to other modules.
+	app = New("").Install(&testScopedModule{uri: "postgres://"}, &testDBURIModule{})
+	err = app.Validate()
+	assert.EqualError(t, err, "*app.testDBURIModule.Start() requires app.DBURI, which is not bound by any module")
+}
+
+type testDBURIModule struct{}
+
+func (t *testDBURIModule) Start(uri DBURI) {}
+
+func TestAppGraph(t *testing.T) {
+	app := New("").Install(&testModuleA{}, &testModuleB{})
+	w := &strings.Builder{}
+	err := app.Graph(w)
+	assert.NoError(t, err)
+	assert.Equal(t, `digraph app {
+  "testmodulea.0" [shape=box, label="*app.testModuleA"];
+  "app.DB" [shape=ellipse];
+  "testmodulea.0" -> "app.DB";
+  "app.DBURI" [shape=ellipse];
+  "app.DBURI" -> "testmodulea.0" [style=dashed];
+  "testmoduleb.1" [shape=box, label="*app.testModuleB"];
+  "testmoduleb.1" -> "app.DBURI";
+}
+`, w.String())
+
+	// Instances of the same type are separate nodes.
+	app = New("").Install(&testModuleA{}, &testModuleB{}, &testDBConsumerModule{"a"}, &testDBConsumerModule{"b"})
+	w.Reset()
+	err = app.Graph(w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), `
+  "testdbconsumermodule.2" [shape=box, label="*app.testDBConsumerModule"];
+  "app.DB" -> "testdbconsumermodule.2" [style=dashed];
+  "testdbconsumermodule.3" [shape=box, label="*app.testDBConsumerModule"];
+  "app.DB" -> "testdbconsumermodule.3" [style=dashed];
+`)
+}
+
+type testDBConsumerModule struct{ name string }
+
+func (t *testDBConsumerModule) Start(db DB) {}
+
+type Pool string
+
+type testPoolModule struct {
+	err     error
+	created int
+}
+
+func (t *testPoolModule) ProvidePool(uri DBURI) (Pool, error) {
+	t.created++
+	return Pool("Pool:" + uri), t.err
+}
+
+func (t *testPoolModule) Eager() []reflect.Type { return []reflect.Type{reflect.TypeOf(Pool(""))} }
+
+func TestAppEager(t *testing.T) {
+	pool := &testPoolModule{}
+	app := New("").Install(pool, &testModuleB{})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pool.created)
+	order, err := app.Order()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{&testModuleB{}, pool}, order)
+
+	stopped := []string{}
+	pool = &testPoolModule{err: fmt.Errorf("connection refused")}
+	app = New("").Install(&testStopModule{name: "a", stopped: &stopped}, pool, &testModuleB{})
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testPoolModule.Start(): eager app.Pool: connection refused")
+	assert.Equal(t, []string{"a"}, stopped)
+}
+
+type testGroupModule struct {
+	err error
+}
+
+func (t *testGroupModule) Start(lifecycle Lifecycle) {
+	lifecycle.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	lifecycle.Go(func(ctx context.Context) error { return t.err })
+}
+
+type testBlockingApp struct{}
+
+func (t *testBlockingApp) Blocking()                 {}
+func (t *testBlockingApp) Start(ctx context.Context) { <-ctx.Done() }
+
+type testShutdownModule struct{}
+
+func (t *testShutdownModule) Start(shutdown Shutdown) {
+	shutdown()
+	shutdown()
+}
+
+type testPhaseQueryModule struct {
+	lifecycle Lifecycle
+	phases    []Phase
+}
+
+func (t *testPhaseQueryModule) Start(lifecycle Lifecycle) {
+	t.lifecycle = lifecycle
+	t.phases = append(t.phases, lifecycle.Phase())
+}
+
+func (t *testPhaseQueryModule) Stop() { t.phases = append(t.phases, t.lifecycle.Phase()) }
+
+type testPhaseQueryApp struct {
+	module *testPhaseQueryModule
+}
+
+func (t *testPhaseQueryApp) Start(lifecycle Lifecycle) {
+	t.module.phases = append(t.module.phases, lifecycle.Phase())
+	lifecycle.Shutdown()
+	t.module.phases = append(t.module.phases, lifecycle.Phase())
+}
+
+func TestAppPhase(t *testing.T) {
+	module := &testPhaseQueryModule{}
+	err := New("").Install(module).RunWithArgs([]string{}, &testPhaseQueryApp{module})
+	assert.NoError(t, err)
+	module.phases = append(module.phases, module.lifecycle.Phase())
+	assert.Equal(t, []Phase{PhaseStarting, PhaseRunning, PhaseStopping, PhaseStopping, PhaseStopped}, module.phases)
+	assert.Equal(t, "running", PhaseRunning.String())
+}
+
+func TestAppShutdown(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(&testStopModule{name: "a", stopped: &stopped}, &testShutdownModule{})
+	err := app.RunWithArgs([]string{}, &testBlockingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, stopped)
+}
+
+type testBarrierModule struct {
+	name   string
+	serve  bool
+	err    error
+	lock   *sync.Mutex
+	events *[]string
+}
+
+func (t *testBarrierModule) record(event string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	*t.events = append(*t.events, event)
+}
+
+func (t *testBarrierModule) Start(lifecycle Lifecycle) error {
+	if t.serve {
+		lifecycle.Go(func(ctx context.Context) error {
+			t.record(t.name + " serving")
+			return nil
+		})
+	}
+	t.record(t.name + " started")
+	return t.err
+}
+
+func TestAppBarrierBeforeServe(t *testing.T) {
+	lock := &sync.Mutex{}
+	events := []string{}
+	app := New("").BarrierBeforeServe(true).Install(
+		&testBarrierModule{name: "http", serve: true, lock: lock, events: &events},
+		&testBarrierModule{name: "metrics", lock: lock, events: &events},
+	)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http started", "metrics started", "http serving"}, events)
+
+	events = []string{}
+	app = New("").BarrierBeforeServe(true).Install(
+		&testBarrierModule{name: "http", serve: true, lock: lock, events: &events},
+		&testBarrierModule{name: "metrics", err: fmt.Errorf("failed"), lock: lock, events: &events},
+	)
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.EqualError(t, err, "*app.testBarrierModule.Start(): failed")
+	assert.Equal(t, []string{"http started", "metrics started"}, events)
+}
+
+type testGuardModule struct {
+	lock    sync.Mutex
+	events  []string
+	release bool
+}
+
+func (t *testGuardModule) record(event string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *testGuardModule) Start(guard ShutdownGuard) {
+	guard.Hold()
+	if !t.release {
+		return
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		t.record("committed")
+		guard.Release()
+	}()
+}
+
+func (t *testGuardModule) Stop() { t.record("stopped") }
+
+func TestAppShutdownGuard(t *testing.T) {
+	module := &testGuardModule{release: true}
+	err := New("").Install(module).RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"committed", "stopped"}, module.events)
+
+	logger := &testLogger{}
+	module = &testGuardModule{}
+	app := New("").Logger(logger).MaxShutdownHold(20 * time.Millisecond).Install(module)
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stopped"}, module.events)
+	assert.Contains(t, logger.lines, "warn: shutdown still held after 20ms, stopping anyway")
+}
+
+func TestAppLifecycleGo(t *testing.T) {
+	app := New("").Install(&testGroupModule{err: fmt.Errorf("listen failed")})
+	err := app.RunWithArgs([]string{}, &testBlockingApp{})
+	assert.EqualError(t, err, "listen failed")
+
+	app = New("").Install(&testGroupModule{})
+	err = app.RunWithArgs([]string{}, &testFailingApp{err: fmt.Errorf("start failed")})
+	assert.EqualError(t, err, "*app.testFailingApp.Start(): start failed")
+}
+
+type testFakeDBModule struct{}
+
+func (t *testFakeDBModule) ProvideDB() DB { return DB("fake") }
+
+func TestAppDuplicateProviders(t *testing.T) {
+	app := New("").Install(&testModuleA{}, &testModuleB{}, &testFakeDBModule{})
+	err := app.RunWithArgs([]string{}, &testApp{})
+	assert.EqualError(t, err, "*app.testFakeDBModule: install: app.DB is provided by both *app.testModuleA.ProvideDB() "+
+		"and *app.testFakeDBModule.ProvideDB(), use Replace() to override one, or make one Scoped to keep it private")
+	assert.Error(t, app.Validate())
+}
+
+func TestAppReplace(t *testing.T) {
+	myApp := &testApp{}
+	app := New("").Install(&testModuleA{}, &testModuleB{}).Replace(&testFakeDBModule{})
+	err := app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("fake"), myApp.db)
+}
+
+type testFatalModule struct{}
+
+func (t *testFatalModule) Start(lifecycle Lifecycle) {
+	lifecycle.FatalIfError(fmt.Errorf("connection refused"), "failed to connect to %s", "db")
+}
+
+func TestAppLifecycleFatalf(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(&testStopModule{name: "a", stopped: &stopped}, &testFatalModule{})
+	err := app.RunWithArgs([]string{}, &testBlockingApp{})
+	assert.EqualError(t, err, "failed to connect to db: connection refused")
+	assert.Equal(t, []string{"a"}, stopped)
+
+	stopped = []string{}
+	app = New("").Install(&testStopModule{name: "a", stopped: &stopped})
+	err = app.RunWithArgs([]string{}, &testFatalApp{app})
+	assert.EqualError(t, err, "fatal")
+	assert.Equal(t, []string{"a"}, stopped)
+}
+
+type testFatalApp struct{ app *Application }
+
+func (t *testFatalApp) Start() { t.app.Fatalf("fatal") }
+
+type testServeModule struct {
+	Port    int `help:"Port to listen on."`
+	started bool
+}
+
+func (t *testServeModule) Start() { t.started = true }
+
+func TestAppCommandModules(t *testing.T) {
+	serve := &testServeModule{}
+	app := New("").Install(&testModuleB{})
+	app.Command("serve", "Serve.")
+	app.Command("migrate", "Migrate.")
+	app.CommandModules("serve", serve, &testModuleA{})
+	app.CommandModules("migrate", &testModuleA{})
+	myApp := &testApp{}
+	err := app.RunWithArgs([]string{"migrate"}, myApp)
+	assert.NoError(t, err)
+	assert.False(t, serve.started)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:"), myApp.db)
+
+	serve = &testServeModule{}
+	app = New("").Install(&testModuleA{}, &testModuleB{})
+	app.Command("serve", "Serve.")
+	app.CommandModules("serve", serve)
+	err = app.RunWithArgs([]string{"serve", "--port=8080"}, myApp)
+	assert.NoError(t, err)
+	assert.True(t, serve.started)
+	assert.Equal(t, 8080, serve.Port)
+
+	app = New("").CommandModules("serve", serve)
+	err = app.RunWithArgs([]string{}, myApp)
+	assert.EqualError(t, err, `unknown command "serve"`)
+}
+
+type testCloser struct {
+	name   string
+	closed *[]string
+}
+
+func (t *testCloser) Close() error {
+	*t.closed = append(*t.closed, t.name)
+	return nil
+}
+
+type testCloserModule struct {
+	testCloser
+}
+
+func (t *testCloserModule) ProvideCloser() *testCloser {
+	return &testCloser{name: "eager", closed: t.closed}
+}
+
+func (t *testCloserModule) Eager() []reflect.Type {
+	return []reflect.Type{reflect.TypeOf(&testCloser{})}
+}
+
+type testCloserStartModule struct {
+	closed *[]string
+}
+
+func (t *testCloserStartModule) Start(*testCloser) (io.Closer, error) {
+	return &testCloser{name: "started", closed: t.closed}, nil
+}
+
+func (t *testCloserStartModule) Stop() { *t.closed = append(*t.closed, "stopped") }
+
+func TestAppClosers(t *testing.T) {
+	closed := []string{}
+	app := New("").Install(
+		&testCloserStartModule{closed: &closed},
+		&testCloserModule{testCloser{name: "module", closed: &closed}},
+	)
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stopped", "started", "module", "eager"}, closed)
+
+	// Provided values are only closed if they are Eager types.
+	closed = []string{}
+	app = New("").Install(&testCloserStartModule{closed: &closed}, &testCloserProviderModule{closed: &closed})
+	err = app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stopped", "started"}, closed)
+}
+
+type testCloserProviderModule struct {
+	closed *[]string
+}
+
+func (t *testCloserProviderModule) ProvideCloser() *testCloser {
+	return &testCloser{name: "provided", closed: t.closed}
+}
+
+type testArgsApp struct {
+	args Args
+}
+
+func (t *testArgsApp) Start(args Args) error {
+	t.args = args
+	return nil
+}
+
+func TestAppPositionalArgs(t *testing.T) {
+	myApp := &testArgsApp{}
+	app := New("").PositionalArgs("file", "Files to process.")
+	err := app.RunWithArgs([]string{"a.txt", "b.txt"}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, Args{"a.txt", "b.txt"}, myApp.args)
+
+	err = New("").RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, Args{}, myApp.args)
+}
+
+type testParseContextApp struct {
+	Level  string `help:"Level." default:"info"`
+	Force  bool   `help:"Force."`
+	parsed *ParseContext
+}
+
+func (t *testParseContextApp) Start(parsed *ParseContext) error {
+	t.parsed = parsed
+	return nil
+}
+
+func TestAppParseContext(t *testing.T) {
+	myApp := &testParseContextApp{}
+	err := New("").RunWithArgs([]string{"--force"}, myApp)
+	assert.NoError(t, err)
+	assert.True(t, myApp.parsed.IsSet("force"))
+	assert.Equal(t, []string{"true"}, myApp.parsed.Flag("force"))
+	assert.False(t, myApp.parsed.IsSet("level"))
+	assert.Equal(t, "info", myApp.Level)
+	assert.Equal(t, SelectedCommand(""), myApp.parsed.Command)
+}
+
+type testPhaseModule struct {
+	name  string
+	calls *[]string
+}
+
+func (t *testPhaseModule) record(call string) error {
+	*t.calls = append(*t.calls, t.name+"."+call)
+	return nil
+}
+
+func (t *testPhaseModule) BeforeStart() error { return t.record("BeforeStart") }
+func (t *testPhaseModule) Start() error       { return t.record("Start") }
+func (t *testPhaseModule) AfterStart() error  { return t.record("AfterStart") }
+func (t *testPhaseModule) BeforeStop() error  { return t.record("BeforeStop") }
+func (t *testPhaseModule) Stop() error        { return t.record("Stop") }
+func (t *testPhaseModule) AfterStop() error   { return t.record("AfterStop") }
+
+func TestAppPhaseHooks(t *testing.T) {
+	calls := []string{}
+	app := New("").Install(&testPhaseModule{"a", &calls}, &testPhaseModule{"b", &calls})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"a.BeforeStart", "b.BeforeStart",
+		"a.Start", "b.Start",
+		"a.AfterStart", "b.AfterStart",
+		"b.BeforeStop", "a.BeforeStop",
+		"b.Stop", "a.Stop",
+		"b.AfterStop", "a.AfterStop",
+	}, calls)
+}
+
+func TestAppInstallE(t *testing.T) {
+	app := New("")
+	assert.NoError(t, app.InstallE(&testModuleA{}, func() *testModuleB { return &testModuleB{} }))
+	assert.EqualError(t, app.InstallE(&testModuleA{}, nil), "module 2: module must not be nil")
+	assert.EqualError(t, app.InstallE((*testModuleA)(nil)), "module 1: module *app.testModuleA must not be nil")
+	assert.EqualError(t, app.InstallE(testModuleA{}),
+		"module 1: module must be a pointer to a struct or a module factory, not app.testModuleA")
+	assert.Len(t, app.modules, 2)
+	assert.Panics(t, func() { app.Install("module") })
+}
+
+func TestAppInstallFactoryValidatesModule(t *testing.T) {
+	err := New("").Install(func() *testModuleA { return nil }).RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "module factory func() *app.testModuleA: module *app.testModuleA must not be nil")
+
+	err = New("").Install(func() testModuleA { return testModuleA{} }).RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "module factory func() app.testModuleA: "+
+		"module must be a pointer to a struct or a module factory, not app.testModuleA")
+}
+
+func TestAppOptions(t *testing.T) {
+	out := &bytes.Buffer{}
+	app := New("myapp",
+		WithHelp("My app."),
+		WithVersion("1.2.3"),
+		WithWriters(out, out),
+		WithSignals(),
+		WithStartTimeout(time.Second),
+	)
+	assert.Equal(t, "My app.", app.Model().Help)
+	assert.Equal(t, "1.2.3", app.build.Version)
+	assert.Equal(t, time.Second, app.startTimeout)
+	assert.NotEmpty(t, app.signals)
+}
+
+func TestAppWriters(t *testing.T) {
+	out := &bytes.Buffer{}
+	errs := &bytes.Buffer{}
+	app := New("myapp").Terminate(nil).Writers(out, errs)
+	err := app.RunWithArgs([]string{"--help"}, &testFailingApp{})
+	assert.Equal(t, TerminatedError{Status: 0}, err)
+	assert.Contains(t, out.String(), "usage: myapp")
+
+	app.Errorf("failed")
+	assert.Contains(t, errs.String(), "error: failed")
+
+	app.log().Warnf("careful")
+	assert.Contains(t, errs.String(), "warning: careful")
+}
+
+func TestAppDryRun(t *testing.T) {
+	calls := []string{}
+	err := New("").DryRun(true).Install(&testPhaseModule{"a", &calls}).
+		RunWithArgs([]string{}, &testFailingApp{err: fmt.Errorf("started")})
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+
+	err = New("").DryRun(true).Install(&testModuleA{}).RunWithArgs([]string{}, &testApp{})
+	assert.EqualError(t, err, "*app.testModuleA.ProvideDB() requires app.DBURI, which is not bound by any module")
+}
+
+type testNamedScopedModule struct {
+	testScopedModule
+	name string
+}
+
+func (t *testNamedScopedModule) ModuleName() string { return t.name }
+
+func (t *testNamedScopedModule) Exports() []reflect.Type { return nil }
+
+type testInstancesApp struct {
+	primary, replica DB
+	err              error
+}
+
+func (t *testInstancesApp) Start(instances *Instances) error {
+	if err := instances.Get("primary", &t.primary); err != nil {
+		return err
+	}
+	if err := instances.Get("replica", &t.replica); err != nil {
+		return err
+	}
+	t.err = instances.Get("missing", &t.primary)
+	return nil
+}
+
+func TestAppInstances(t *testing.T) {
+	app := New("").Install(
+		&testNamedScopedModule{testScopedModule{uri: "postgres://primary"}, "primary"},
+		&testNamedScopedModule{testScopedModule{uri: "postgres://replica"}, "replica"},
+	)
+	myApp := &testInstancesApp{}
+	err := app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://primary"), myApp.primary)
+	assert.Equal(t, DB("DB:postgres://replica"), myApp.replica)
+	assert.EqualError(t, myApp.err, `no Scoped module is named "missing"`)
+}
+
+type testServingApp struct {
+	serve func(ctx context.Context) error
+}
+
+func (t *testServingApp) Blocking()                       {}
+func (t *testServingApp) Start(ctx context.Context) error { return t.serve(ctx) }
+
+type testBlockingRunnerModule struct {
+	cancelled bool
+}
+
+func (t *testBlockingRunnerModule) ProvideRunnerSequence() []Runner {
+	return []Runner{func(ctx context.Context) error {
+		<-ctx.Done()
+		t.cancelled = true
+		return ctx.Err()
+	}}
+}
+
+func TestAppBlockingStart(t *testing.T) {
+	// The application module returning begins shutdown, cancelling Runners.
+	blocking := &testBlockingRunnerModule{}
+	app := New("").Install(blocking)
+	err := app.RunWithArgs([]string{}, &testServingApp{func(ctx context.Context) error { return nil }})
+	assert.NoError(t, err)
+	assert.True(t, blocking.cancelled)
+
+	// A failing Runner cancels the application module.
+	module := &testRunnerModule{}
+	app = New("").Install(module)
+	err = app.RunWithArgs([]string{}, &testServingApp{func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	assert.EqualError(t, err, "failed")
+	assert.True(t, module.cancelled)
+
+	// An application module that accepts a context but isn't Blocking runs before the Runners.
+	slow := &testSlowRunnerModule{}
+	err = New("").Install(slow).RunWithArgs([]string{}, &testContextApp{})
+	assert.NoError(t, err)
+	assert.False(t, slow.cancelled)
+}
+
+type testSlowRunnerModule struct{ cancelled bool }
+
+func (t *testSlowRunnerModule) ProvideRunnerSequence() []Runner {
+	return []Runner{func(ctx context.Context) error {
+		select {
+		case <-time.After(time.Millisecond * 20):
+		case <-ctx.Done():
+			t.cancelled = true
+		}
+		return nil
+	}}
+}
+
+type testSleepyModule struct{}
+
+func (t *testSleepyModule) Start() { time.Sleep(time.Millisecond * 10) }
+
+func TestAppStatsFromOnEvent(t *testing.T) {
+	stats := 0
+	app := New("").Install(&testModuleA{}, &testModuleB{})
+	app.OnEvent(func(event Event) { stats = len(app.Stats()) })
+	err := app.RunWithArgs([]string{}, &testApp{})
+	assert.NoError(t, err)
+	assert.NotZero(t, stats)
+}
+
+func TestAppStats(t *testing.T) {
+	app := New("").Install(&testModuleA{}, &testModuleB{}, &testSleepyModule{})
+	err := app.RunWithArgs([]string{}, &testApp{})
+	assert.NoError(t, err)
+	stats := app.Stats()
+	modules := []string{}
+	for _, stat := range stats {
+		modules = append(modules, stat.Module)
+	}
+	assert.Equal(t, []string{"*app.testModuleA", "*app.testModuleB", "*app.testSleepyModule", "*app.testApp"},
+		modules)
+	assert.True(t, stats[2].Start >= time.Millisecond*10)
+}
+
+type testFlushModule struct {
+	flushed *[]string
+}
+
+func (t *testFlushModule) Stop(logger Logger, db DB) {
+	*t.flushed = append(*t.flushed, "flushed to "+string(db))
+}
+
+type testDBStopModule struct {
+	flushed *[]string
+}
+
+func (t *testDBStopModule) ProvideDB() DB { return DB("db") }
+
+func (t *testDBStopModule) Stop() { *t.flushed = append(*t.flushed, "db stopped") }
+
+func TestAppStopInjection(t *testing.T) {
+	flushed := []string{}
+	// The module injecting DB into Stop() is installed first, but stopped before DB's provider.
+	app := New("").Install(&testFlushModule{&flushed}, &testDBStopModule{&flushed})
+	err := app.RunWithArgs([]string{}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"flushed to db", "db stopped"}, flushed)
+
+	app = New("").Install(&testFlushModule{&flushed})
+	assert.EqualError(t, app.Validate(),
+		"*app.testFlushModule.Stop() requires app.DB, which is not bound by any module")
+}
+
+type testNoArgsApp struct{ started bool }
+
+func (t *testNoArgsApp) Start() { t.started = true }
+
+type testInjectedApp struct {
+	command SelectedCommand
+	db      DB
+	ctx     context.Context
+}
+
+func (t *testInjectedApp) Start(ctx context.Context, command SelectedCommand, db DB, lifecycle Lifecycle) error {
+	t.ctx, t.command, t.db = ctx, command, db
+	return nil
+}
+
+type testBadStartApp struct{}
+
+func (t *testBadStartApp) Start() int { return 0 }
+
+func TestAppMainStart(t *testing.T) {
+	noArgs := &testNoArgsApp{}
+	assert.NoError(t, New("").RunWithArgs([]string{}, noArgs))
+	assert.True(t, noArgs.started)
+
+	contextOnly := &testContextApp{}
+	assert.NoError(t, New("").RunWithArgs([]string{}, contextOnly))
+	assert.NotNil(t, contextOnly.ctx)
+
+	injected := &testInjectedApp{}
+	app := New("").Install(&testModuleA{}, &testModuleB{})
+	app.MainCommand("serve", "Serve.", injected)
+	assert.NoError(t, app.RunWithArgs([]string{"serve"}, nil))
+	assert.Equal(t, SelectedCommand("serve"), injected.command)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:"), injected.db)
+	assert.NotNil(t, injected.ctx)
+
+	err := New("").RunWithArgs([]string{}, &testBadStartApp{})
+	assert.EqualError(t, err,
+		"*app.testBadStartApp.Start() must return nothing, an error, or values followed by an error, not func() int")
+
+	err = New("").RunWithArgs([]string{}, &testModuleA{})
+	assert.EqualError(t, err, "no Start(...) method on application module")
+}
+
+type testReloadModule struct {
+	HTTPBind string `help:"Bind address." default:":80"`
+	reloaded string
+}
+
+func (t *testReloadModule) ModuleName() string { return "http" }
+
+func (t *testReloadModule) Reload(binder Binder) error {
+	t.reloaded = t.HTTPBind
+	return nil
+}
+
+type testReloadApp struct {
+	path string
+	err  error
+}
+
+func (t *testReloadApp) Start(app *Application) error {
+	if err := ioutil.WriteFile(t.path, []byte("http:\n  http-bind: \":9090\"\n"), 0600); err != nil {
+		return err
+	}
+	return app.Reload()
+}
+
+func TestAppReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte("http:\n  http-bind: \":8080\"\n"), 0600)
+	assert.NoError(t, err)
+
+	module := &testReloadModule{}
+	app := New("").ConfigFile(path).Install(module)
+	err = app.RunWithArgs([]string{}, &testReloadApp{path: path})
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", module.HTTPBind)
+	assert.Equal(t, ":9090", module.reloaded)
+
+	assert.EqualError(t, app.Reload(), "can't reload as the application is not running")
+}
+
+type testResolverApp struct {
+	db    DB
+	cache Cache
+}
+
+func (t *testResolverApp) Start(resolver Resolver) error {
+	db, err := resolver.Get(reflect.TypeOf(DB("")))
+	if err != nil {
+		return err
+	}
+	t.db = db.(DB)
+	_, err = resolver.Call(func(cache Cache) { t.cache = cache })
+	return err
+}
+
+type testCacheProviderModule struct{}
+
+func (t *testCacheProviderModule) ProvideCache(db DB) Cache { return Cache("Cache:" + db) }
+
+func TestAppResolver(t *testing.T) {
+	myApp := &testResolverApp{}
+	err := New("").Install(&testModuleA{}, &testModuleB{}, &testCacheProviderModule{}).RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:"), myApp.db)
+	assert.Equal(t, Cache("Cache:DB:postgres://127.0.0.1:"), myApp.cache)
+}
+
+type testHTTPTimeoutModule struct {
+	Timeout string `help:"Timeout." default:"1s"`
+}
+
+func (t *testHTTPTimeoutModule) ModuleName() string { return "http" }
+
+type testDBTimeoutModule struct {
+	Timeout string `help:"Timeout." default:"1s"`
+	Debug   bool   `help:"Debug."`
+}
+
+func (t *testDBTimeoutModule) FlagNamespace() string { return "db" }
+
+func TestAppNamespaceFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "app")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	err = ioutil.WriteFile(path, []byte("http:\n  timeout: 5s\n"), 0600)
+	assert.NoError(t, err)
+
+	httpModule := &testHTTPTimeoutModule{}
+	dbModule := &testDBTimeoutModule{}
+	app := New("").NamespaceFlags(true).ConfigFile(path).Install(httpModule, dbModule)
+	err = app.RunWithArgs([]string{"--db.timeout=2s", "--db.debug"}, &testFailingApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, "5s", httpModule.Timeout)
+	assert.Equal(t, "2s", dbModule.Timeout)
+	assert.True(t, dbModule.Debug)
+}
+
+func TestAppMount(t *testing.T) {
+	sub := New("tool", WithHelp("Tool.")).Install(&testModuleA{}, &testModuleB{})
+	injected := &testInjectedApp{}
+	other := &testNoArgsApp{}
+	app := New("")
+	app.Mount("tool", sub, injected)
+	app.MainCommand("other", "Other.", other)
+
+	err := app.RunWithArgs([]string{"tool", "--test=mounted"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, SelectedCommand("tool"), injected.command)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:mounted"), injected.db)
+
+	// The sub-application's modules are not configured for other commands.
+	err = app.RunWithArgs([]string{"other"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, other.started)
+}
+
+func TestAppMountUnsupportedClauses(t *testing.T) {
+	sub := New("tool")
+	sub.MainCommand("run", "Run.", &testNoArgsApp{}).Flag("force", "Force.").Bool()
+	app := New("")
+	app.Mount("tool", sub, nil)
+	err := app.RunWithArgs([]string{"tool", "run"}, nil)
+	assert.EqualError(t, err, `mount "tool": can't mount flag --force of command "run"`)
+
+	sub = New("tool")
+	sub.Flag("force", "Force.").Bool()
+	app = New("")
+	app.Mount("tool", sub, &testNoArgsApp{})
+	err = app.RunWithArgs([]string{"tool"}, nil)
+	assert.EqualError(t, err, `mount "tool": can't mount flag --force`)
+
+	sub = New("tool")
+	sub.Command("plain", "Plain.")
+	app = New("")
+	app.Mount("tool", sub, &testNoArgsApp{})
+	err = app.RunWithArgs([]string{"tool"}, nil)
+	assert.EqualError(t, err, `mount "tool": can't mount command "plain" without a module`)
+}
+
+type testBindingModule struct {
+	uri DBURI
+}
+
+func (t *testBindingModule) Configure(binder Binder) error { return binder.Bind(t.uri) }
+
+func TestAppBindingErrorsIdentifyModule(t *testing.T) {
+	err := New("").Install(&testModuleB{}, &testFakeDBModule{}, &testCacheProviderModule{}, &testModuleA{}).
+		RunWithArgs([]string{}, &testApp{})
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "*app.testModuleA: install: "), err.Error())
+
+	err = New("").Install(&testModuleB{}, &testBindingModule{"postgres://"}).RunWithArgs([]string{}, &testApp{})
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "*app.testBindingModule.Configure(): "), err.Error())
+}
+
+func TestAppBuild(t *testing.T) {
+	stopped := []string{}
+	app := New("").Install(&testModuleA{}, &testModuleB{}, &testStopModule{name: "module", stopped: &stopped})
+	r, err := app.Build([]string{"--test=built"}, &testBlockingApp{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Start())
+	assert.EqualError(t, r.Start(), "application already started")
+
+	// The application is running until stopped.
+	var db DB
+	_, err = app.Call(func(d DB) { db = d })
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:built"), db)
+	assert.Empty(t, stopped)
+
+	assert.NoError(t, r.Stop())
+	assert.NoError(t, r.Stop())
+	assert.Equal(t, []string{"module"}, stopped)
+	_, err = app.Call(func(d DB) {})
+	assert.Error(t, err)
+}
+
+func TestAppDefaultCommand(t *testing.T) {
+	serve := &testInjectedApp{}
+	migrate := &testNoArgsApp{}
+	app := New("").Install(&testModuleA{}, &testModuleB{}).DefaultCommand("serve")
+	app.MainCommand("serve", "Serve.", serve)
+	app.MainCommand("migrate", "Migrate.", migrate)
+	err := app.RunWithArgs([]string{}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, SelectedCommand("serve"), serve.command)
+	assert.False(t, migrate.started)
+
+	err = New("").DefaultCommand("missing").RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, `default command: unknown command "missing"`)
+}
+
+func TestAppAlias(t *testing.T) {
+	serve := &testInjectedApp{}
+	app := New("").Install(&testModuleA{}, &testModuleB{}).Alias("serve", "server", "run")
+	app.MainCommand("serve", "Serve.", serve)
+	for _, command := range []string{"serve", "server", "run"} {
+		serve.command = ""
+		err := app.RunWithArgs([]string{command}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, SelectedCommand("serve"), serve.command)
+	}
+	assert.Equal(t, []string{"server", "run"}, app.GetCommand("serve").Model().Aliases)
+
+	err := New("").Alias("missing", "absent").RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, `alias: unknown command "missing"`)
+}
+
+type testEntryServer struct{ name string }
+
+type testEntryModule struct{ started DB }
+
+func (t *testEntryModule) EntryPoint() interface{} { return t.Serve }
+
+func (t *testEntryModule) Serve(db DB) (*testEntryServer, error) {
+	t.started = db
+	return &testEntryServer{name: "server"}, nil
+}
+
+type testEntryApp struct{ server *testEntryServer }
+
+func (t *testEntryApp) EntryPoint() interface{} { return t.Run }
+
+func (t *testEntryApp) Run(server *testEntryServer) { t.server = server }
+
+type testBadEntryModule struct{}
+
+func (t *testBadEntryModule) EntryPoint() interface{} { return "Serve" }
+
+func TestAppEntryPoint(t *testing.T) {
+	module := &testEntryModule{}
+	main := &testEntryApp{}
+	app := New("").Install(module, &testModuleA{}, &testModuleB{})
+	order, err := app.Order()
+	assert.NoError(t, err)
+	assert.Equal(t, module, order[len(order)-1])
+	assert.True(t, Describe(module).Starter)
+
+	err = app.RunWithArgs([]string{"--test=entry"}, main)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:entry"), module.started)
+	assert.Equal(t, &testEntryServer{name: "server"}, main.server)
+
+	err = New("").Install(&testBadEntryModule{}).RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "*app.testBadEntryModule.EntryPoint() must return a function, not string")
+}
+
+type testProviderOnlyModule struct{}
+
+func (t *testProviderOnlyModule) ProvideURI() DBURI { return DBURI("postgres://127.0.0.1") }
+
+type testProviderStopModule struct {
+	testStopModule
+}
+
+func (t *testProviderStopModule) ProvideDB(uri DBURI) DB { return DB("DB:" + uri) }
+
+func TestAppProviderOnlyModules(t *testing.T) {
+	logger := &testLogger{}
+	stopped := []string{}
+	stopper := &testProviderStopModule{testStopModule{name: "db", stopped: &stopped}}
+	app := New("").Logger(logger).Install(&testProviderOnlyModule{}, stopper)
+	myApp := &testApp{}
+	err := app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1"), myApp.db)
+	assert.Equal(t, []string{"db"}, stopped)
+	assert.Contains(t, logger.lines,
+		"debug: *app.testProviderOnlyModule has no lifecycle methods, it only provides types")
+	for _, line := range logger.lines {
+		assert.NotContains(t, line, "testProviderStopModule has no lifecycle methods")
+	}
+}
+
+type testSupervisedModule struct {
+	fail    func() error
+	exited  int32
+	stopped bool
+}
+
+func (t *testSupervisedModule) Start(supervisor Supervisor) {
+	supervisor.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		atomic.StoreInt32(&t.exited, 1)
+		return ctx.Err()
+	})
+	supervisor.Go(func(ctx context.Context) error { return t.fail() })
+}
+
+// Stop records whether the supervised goroutines had all returned.
+func (t *testSupervisedModule) Stop() { t.stopped = atomic.LoadInt32(&t.exited) == 1 }
+
+func TestAppSupervisor(t *testing.T) {
+	module := &testSupervisedModule{fail: func() error { return fmt.Errorf("worker died") }}
+	err := New("").Install(module).RunWithArgs([]string{}, &testBlockingApp{})
+	assert.EqualError(t, err, "worker died")
+	assert.True(t, module.stopped)
+
+	module = &testSupervisedModule{fail: func() error { panic("boom") }}
+	err = New("").Install(module).RunWithArgs([]string{}, &testBlockingApp{})
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "supervised goroutine panicked: boom\n"), err.Error())
+	assert.True(t, module.stopped)
+}
+
+type testDeconfigureModule struct {
+	name         string
+	err          error
+	deconfigured *[]string
+}
+
+func (t *testDeconfigureModule) Configure(binder Binder) error { return t.err }
+
+func (t *testDeconfigureModule) Deconfigure() error {
+	*t.deconfigured = append(*t.deconfigured, t.name)
+	return nil
+}
+
+func TestAppDeconfigure(t *testing.T) {
+	deconfigured := []string{}
+	app := New("").Install(
+		&testDeconfigureModule{name: "a", deconfigured: &deconfigured},
+		&testDeconfigureModule{name: "b", deconfigured: &deconfigured},
+		&testDeconfigureModule{name: "c", err: fmt.Errorf("connection refused"), deconfigured: &deconfigured},
+		&testDeconfigureModule{name: "d", deconfigured: &deconfigured},
+	)
+	err := app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "*app.testDeconfigureModule.Configure(): connection refused")
+	assert.Equal(t, []string{"b", "a"}, deconfigured)
+
+	// Started modules are not deconfigured.
+	deconfigured = []string{}
+	app = New("").Install(&testDeconfigureModule{name: "a", deconfigured: &deconfigured})
+	err = app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.NoError(t, err)
+	assert.Empty(t, deconfigured)
+
+	// Modules of a prepared application that is stopped without being started are deconfigured.
+	r, err := app.Build([]string{}, &testNoArgsApp{})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Stop())
+	assert.Equal(t, []string{"a"}, deconfigured)
+}
+
+func TestAppCompletion(t *testing.T) {
+	out := &bytes.Buffer{}
+	app := New("myapp").Terminate(nil).Install(&testModuleA{}, &testModuleB{})
+	app.Writers(out, ioutil.Discard)
+	app.MainCommand("serve", "Serve.", &testApp{})
+	app.Command("db", "Database.").Command("migrate", "Migrate.")
+	err := app.RunWithArgs([]string{"--completion-script-bash"}, nil)
+	assert.Equal(t, TerminatedError{Status: 0}, err)
+	script := out.String()
+	assert.Contains(t, script, "complete -F _myapp_bash_autocomplete myapp")
+	assert.Contains(t, script, "--completion-bash")
+
+	out.Reset()
+	assert.NoError(t, app.GenerateCompletion("zsh", out))
+	assert.True(t, strings.HasPrefix(out.String(), "#compdef myapp"), out.String())
+	assert.EqualError(t, app.GenerateCompletion("fish", out), `unsupported shell "fish"`)
+}
+
+func TestAppManPage(t *testing.T) {
+	out := &bytes.Buffer{}
+	app := New("myapp", WithHelp("My app.")).Terminate(nil).Install(&testConfigModule{})
+	app.Writers(out, ioutil.Discard)
+	app.MainCommand("serve", "Serve requests.", &testNoArgsApp{})
+	err := app.RunWithArgs([]string{"--help-man"}, nil)
+	assert.Equal(t, TerminatedError{Status: 0}, err)
+	page := out.String()
+	assert.True(t, strings.HasPrefix(page, ".TH myapp 1"), page)
+	assert.Contains(t, page, "--http-bind")
+	assert.Contains(t, page, "Bind address.")
+	assert.Contains(t, page, "serve")
+	assert.Contains(t, page, "Serve requests.")
+}
+
+type testBatchApp struct {
+	completed int32
+	stopped   int32
+}
+
+func (t *testBatchApp) Start(tasks Tasks) {
+	for i := 0; i < 3; i++ {
+		tasks.Add(1)
+		go func() {
+			defer tasks.Done()
+			time.Sleep(time.Millisecond * 10)
+			atomic.AddInt32(&t.completed, 1)
+		}()
+	}
+}
+
+type testBatchStopModule struct{ app *testBatchApp }
+
+// Stop records how many tasks had completed.
+func (t *testBatchStopModule) Stop() {
+	atomic.StoreInt32(&t.app.stopped, atomic.LoadInt32(&t.app.completed))
+}
+
+func TestAppTasks(t *testing.T) {
+	myApp := &testBatchApp{}
+	err := New("").Install(&testBatchStopModule{myApp}).RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), myApp.stopped)
+
+	// Shutting down stops waiting for outstanding tasks.
+	err = New("").RunWithArgs([]string{}, &testPendingTaskApp{})
+	assert.NoError(t, err)
+}
+
+// testBlockingBatchApp queues tasks from a blocking Start(...), then returns.
+type testBlockingBatchApp struct{ testBatchApp }
+
+func (t *testBlockingBatchApp) Blocking() {}
+
+func (t *testBlockingBatchApp) Start(ctx context.Context, tasks Tasks) error {
+	t.testBatchApp.Start(tasks)
+	return nil
+}
+
+// testBatchRunnerModule provides a Runner that queues tasks, then returns.
+type testBatchRunnerModule struct{ app *testBatchApp }
+
+func (t *testBatchRunnerModule) ProvideRunnerSequence(tasks Tasks) []Runner {
+	return []Runner{func(ctx context.Context) error {
+		t.app.Start(tasks)
+		return nil
+	}}
+}
+
+func TestAppTasksAfterRunners(t *testing.T) {
+	blocking := &testBlockingBatchApp{}
+	err := New("").Install(&testBatchStopModule{&blocking.testBatchApp}).RunWithArgs([]string{}, blocking)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), blocking.stopped)
+
+	batch := &testBatchApp{}
+	err = New("").
+		Install(&testBatchRunnerModule{batch}, &testBatchStopModule{batch}).
+		RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), batch.stopped)
+}
+
+// testGoApp starts a runner with Lifecycle.Go() that returns once the root context is cancelled.
+type testGoApp struct {
+	err error
+}
+
+func (t *testGoApp) Start(lifecycle Lifecycle) {
+	lifecycle.Go(func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second * 5):
+			t.err = fmt.Errorf("context not cancelled")
+			return t.err
+		}
+	})
+}
+
+func TestAppContextCancelledWithoutRunners(t *testing.T) {
+	main := &testGoApp{}
+	err := New("").RunWithArgs([]string{}, main)
+	assert.NoError(t, err)
+	assert.NoError(t, main.err)
+}
+
+type testPendingTaskApp struct{}
+
+func (t *testPendingTaskApp) Start(tasks Tasks, lifecycle Lifecycle) {
+	tasks.Add(1)
+	lifecycle.Shutdown()
+}
+
+func TestAppBind(t *testing.T) {
+	myApp := &testApp{}
+	app := New("").Bind(DBURI("mysql://bound")).Install(&testModuleA{})
+	err := app.RunWithArgs([]string{"--test=seeded"}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:mysql://bound:seeded"), myApp.db)
+
+	// Modules take precedence.
+	myApp = &testApp{}
+	app = New("").Bind(DBURI("mysql://bound")).Install(&testModuleA{}, &testModuleB{})
+	err = app.RunWithArgs([]string{"--test=seeded"}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("DB:postgres://127.0.0.1:seeded"), myApp.db)
+
+	myApp = &testApp{}
+	app = New("").Bind(DBURI("mysql://bound")).Provide(func(uri DBURI) DB { return DB("provided:" + uri) })
+	err = app.RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, DB("provided:mysql://bound"), myApp.db)
+
+	err = New("").Provide("DB").RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "provide: string is not a provider function")
+}
+
+type testValuesModule struct{ err error }
+
+func (t *testValuesModule) Configure(binder Binder) error {
+	return ConfigValues(binder).Set("tenant", "acme")
+}
+
+func (t *testValuesModule) Start(values *Values) { t.err = values.Set("tenant", "other") }
+
+type testValuesApp struct{ tenant interface{} }
+
+func (t *testValuesApp) Start(values *Values) { t.tenant, _ = values.Get("tenant") }
+
+func TestAppValues(t *testing.T) {
+	module := &testValuesModule{}
+	myApp := &testValuesApp{}
+	err := New("").Install(module).RunWithArgs([]string{}, myApp)
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", myApp.tenant)
+	assert.EqualError(t, module.err, `can't set value "tenant" as values are frozen once modules are configured`)
+	assert.Nil(t, ConfigValues(nil))
+}
+
+type testDrainModule struct {
+	testStopModule
+	block bool
+}
+
+func (t *testDrainModule) Drain(ctx context.Context) error {
+	*t.stopped = append(*t.stopped, "drain "+t.name)
+	if t.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+type testDrainDependentModule struct {
+	testDrainModule
+}
+
+func (t *testDrainDependentModule) Start(db DB) {}
+
+func TestAppDrain(t *testing.T) {
+	stopped := []string{}
+	db := &testDrainModule{testStopModule: testStopModule{name: "db", stopped: &stopped}}
+	http := &testDrainDependentModule{testDrainModule{testStopModule: testStopModule{name: "http", stopped: &stopped}}}
+	app := New("").Install(http, db, &testFakeDBModule{})
+	err := app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"drain http", "drain db", "http", "db"}, stopped)
+
+	stopped = []string{}
+	db.block = true
+	app = New("", WithDrainTimeout(time.Millisecond*10)).Install(db)
+	err = app.RunWithArgs([]string{}, &testNoArgsApp{})
+	assert.EqualError(t, err, "*app.testDrainModule.Drain(): context deadline exceeded")
+	assert.Equal(t, []string{"drain db", "db"}, stopped)
+}
@@ -0,0 +1,162 @@
+// Package apptest provides a Harness for testing app modules in isolation.
+//
+// A Harness runs the full module lifecycle, but never parses os.Args or exits the process:
+//
+//		h := apptest.New(&mongo.Module{URI: "mongodb://127.0.0.1"}).Bind(logger)
+//		err := h.Start()
+//		defer h.Stop()
+//		err = h.Call(func(db *mgo.Database) {
+//			// Assert on db...
+//		})
+package apptest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/alecthomas/app"
+)
+
+// Harness runs a set of modules for testing.
+type Harness struct {
+	app       *app.Application
+	args      []string
+	lifecycle app.Lifecycle
+	done      chan error
+}
+
+// New creates a Harness for the given modules.
+func New(modules ...interface{}) *Harness {
+	a := app.New("apptest")
+	a.Terminate(nil)
+	return &Harness{
+		app:  a.Install(modules...),
+		args: []string{},
+	}
+}
+
+// Application returns the underlying Application, eg. to configure it before Start().
+func (h *Harness) Application() *app.Application {
+	return h.app
+}
+
+// Args sets the command-line arguments that are parsed into the modules' flags.
+func (h *Harness) Args(args ...string) *Harness {
+	h.args = args
+	return h
+}
+
+// Bind values into the injector, eg. to provide types the modules require.
+func (h *Harness) Bind(values ...interface{}) *Harness {
+	h.app.Install(&bindings{values})
+	return h
+}
+
+// WithT logs with t.Logf(), so that log output from the application and the modules' injected app.Logger appears
+// with the output of the test, including debug messages.
+//
+// The Harness is stopped when the test completes, if it is still running, as logging after then panics.
+func (h *Harness) WithT(t testing.TB) *Harness {
+	h.app.Logger(testLogger{t})
+	t.Cleanup(func() {
+		if err := h.Stop(); err != nil {
+			t.Errorf("stop: %s", err)
+		}
+	})
+	return h
+}
+
+// Replace the providers of the modules with those of the given modules (see app.Application.Replace()).
+func (h *Harness) Replace(overrides ...interface{}) *Harness {
+	h.app.Replace(overrides...)
+	return h
+}
+
+// Start configures and starts the modules, returning once they have all started.
+//
+// If starting fails, any modules that did start are stopped, and the error is returned.
+func (h *Harness) Start() error {
+	if h.done != nil {
+		return fmt.Errorf("harness already started")
+	}
+	h.done = make(chan error, 1)
+	ready := make(chan app.Lifecycle, 1)
+	go func() {
+		h.done <- h.app.RunWithArgs(h.args, &harnessModule{ready})
+	}()
+	select {
+	case h.lifecycle = <-ready:
+		return nil
+	case err := <-h.done:
+		h.done = nil
+		return err
+	}
+}
+
+// Call f with its arguments obtained from the injector, returning any error it returns.
+//
+// The Harness must be started.
+func (h *Harness) Call(f interface{}) error {
+	_, err := h.app.Call(f)
+	return err
+}
+
+// Stop the modules, returning any errors from their Stop(...) methods.
+//
+// It is safe to call Stop() if the Harness did not start.
+func (h *Harness) Stop() error {
+	if h.done == nil {
+		return nil
+	}
+	h.lifecycle.Shutdown()
+	err := <-h.done
+	h.done = nil
+	return err
+}
+
+// bindings is a module binding values into the injector.
+type bindings struct {
+	values []interface{}
+}
+
+func (b *bindings) Configure(binder app.Binder) error {
+	return binder.Bind(b.values...)
+}
+
+// harnessModule is the application module, running until the Harness is stopped.
+type harnessModule struct {
+	ready chan app.Lifecycle
+}
+
+func (h *harnessModule) Blocking() {}
+
+func (h *harnessModule) Start(ctx context.Context, lifecycle app.Lifecycle) {
+	h.ready <- lifecycle
+	<-ctx.Done()
+}
+
+// testLogger is an app.Logger logging to a test, with the same level prefixes as the default app.Logger.
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Debugf(format string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf("debug: "+format, args...)
+}
+
+func (l testLogger) Infof(format string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf(format, args...)
+}
+
+func (l testLogger) Warnf(format string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf("warning: "+format, args...)
+}
+
+func (l testLogger) Errorf(format string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf("error: "+format, args...)
+}
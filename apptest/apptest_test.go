@@ -0,0 +1,75 @@
+package apptest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/alecthomas/app"
+)
+
+type Greeting string
+
+type testModule struct {
+	Name    string `help:"Name to greet." default:"world"`
+	stopped bool
+}
+
+func (t *testModule) ProvideGreeting(prefix string) Greeting {
+	return Greeting(fmt.Sprintf("%s %s", prefix, t.Name))
+}
+
+func (t *testModule) Stop() { t.stopped = true }
+
+func TestHarness(t *testing.T) {
+	module := &testModule{}
+	h := New(module).Bind("hello").Args("--name=bob")
+	err := h.Start()
+	assert.NoError(t, err)
+	var greeting Greeting
+	err = h.Call(func(g Greeting) { greeting = g })
+	assert.NoError(t, err)
+	assert.Equal(t, Greeting("hello bob"), greeting)
+	assert.NoError(t, h.Stop())
+	assert.True(t, module.stopped)
+
+	err = New(module).Start()
+	assert.EqualError(t, err, "*apptest.testModule.ProvideGreeting() requires string, which is not bound by any module")
+}
+
+type testLoggingModule struct{}
+
+func (t *testLoggingModule) Start(logger app.Logger) {
+	logger.Infof("started %d workers", 4)
+	logger.Warnf("cache is cold")
+}
+
+// recordingT records the messages logged to it.
+type recordingT struct {
+	testing.TB
+	lines []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Logf(format string, args ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+
+func TestHarnessWithT(t *testing.T) {
+	rt := &recordingT{TB: t}
+	h := New(&testLoggingModule{}).WithT(rt)
+	assert.NoError(t, h.Start())
+	assert.NoError(t, h.Stop())
+	assert.Contains(t, rt.lines, "started 4 workers")
+	assert.Contains(t, rt.lines, "warning: cache is cold")
+}
+
+func TestHarnessWithTStopsOnCleanup(t *testing.T) {
+	module := &testModule{}
+	t.Run("Start", func(t *testing.T) {
+		assert.NoError(t, New(module).Bind("hello").WithT(t).Start())
+	})
+	assert.True(t, module.stopped)
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// Args is available for injection, and contains the positional arguments collected by PositionalArgs(), or is empty
+// if none were given.
+type Args []string
+
+// RawArgs is available for injection, and contains the arguments passed to RunWithArgs(), or os.Args[1:] for
+// Run(), before they were parsed, eg. to pass them through to a wrapped program.
+type RawArgs []string
+
+// PositionalArgs collects any positional arguments remaining after flags, eg. file paths, into the injectable Args.
+//
+// The arguments are added to the application itself, so this can't be combined with commands, which should declare
+// their own arguments.
+func (a *Application) PositionalArgs(name, help string) *Application {
+	a.Arg(name, help).StringsVar(&a.args)
+	return a
+}
+
+// ParseContext describes what was actually parsed from the command-line, and is available for injection after
+// parsing as *ParseContext.
+type ParseContext struct {
+	// The underlying kingpin parse context.
+	*kingpin.ParseContext
+	// Command is the full path of the selected command, as with SelectedCommand.
+	Command SelectedCommand
+
+	flags map[string][]string
+}
+
+// IsSet returns true if the named flag was explicitly provided on the command-line, rather than taking its value
+// from its default, the configuration file or the environment.
+func (p *ParseContext) IsSet(flag string) bool {
+	_, ok := p.flags[flag]
+	return ok
+}
+
+// Flag returns the values explicitly provided on the command-line for the named flag, if any.
+func (p *ParseContext) Flag(flag string) []string {
+	return p.flags[flag]
+}
+
+// parseContext parses args into a ParseContext, without applying any values.
+func (a *Application) parseContext(args []string) (*ParseContext, error) {
+	ctx, err := a.Application.ParseContext(args)
+	if err != nil {
+		return nil, err
+	}
+	out := &ParseContext{ParseContext: ctx, flags: map[string][]string{}}
+	if ctx.SelectedCommand != nil {
+		out.Command = SelectedCommand(ctx.SelectedCommand.FullCommand())
+	}
+	// Flags may belong to the application or any command up to the current element.
+	groups := []interface {
+		GetFlag(name string) *kingpin.Clause
+	}{a.Application}
+	for _, element := range ctx.Elements {
+		switch clause := element.Clause.(type) {
+		case *kingpin.CmdClause:
+			groups = append(groups, clause)
+		case *kingpin.Clause:
+			name := clause.Model().Name
+			for _, group := range groups {
+				if element.Value != nil && group.GetFlag(name) == clause {
+					out.flags[name] = append(out.flags[name], *element.Value)
+					break
+				}
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+// BuildInfo describes the application build, and is available for injection, eg. to report the version from a
+// health check.
+//
+// Fields that are not set explicitly with Version() or WithBuildInfo() are populated from the build information
+// embedded by the Go toolchain, if available.
+type BuildInfo struct {
+	Version string
+	// VCS revision the application was built from.
+	Commit string
+	// Time of the commit the application was built from.
+	Date time.Time
+}
+
+// Version sets the application version, and adds a --version flag that displays it.
+//
+// If not set, the version of the main module is used, if it was built with one, eg. via "go install
+// example.com/cmd@v1.2.3".
+func (a *Application) Version(version string) *Application {
+	a.Application.Version(version)
+	a.build.Version = version
+	return a
+}
+
+// WithBuildInfo sets the application BuildInfo.
+//
+// Fields that are not set are populated from the embedded build information, if available. Note that the
+// version is only displayed by --version if set with Version().
+func (a *Application) WithBuildInfo(info BuildInfo) *Application {
+	if info.Version != "" {
+		a.Version(info.Version)
+	}
+	a.build.Commit = info.Commit
+	a.build.Date = info.Date
+	return a
+}
+
+// buildInfo returns the application BuildInfo, populating any unset fields from the embedded build information.
+func (a *Application) buildInfo() BuildInfo {
+	info := a.build
+	embedded := embeddedBuildInfo()
+	if info.Version == "" {
+		info.Version = embedded.Version
+	}
+	if info.Commit == "" {
+		info.Commit = embedded.Commit
+	}
+	if info.Date.IsZero() {
+		info.Date = embedded.Date
+	}
+	return info
+}
+
+// embeddedBuildInfo returns the build information embedded by the Go toolchain.
+func embeddedBuildInfo() BuildInfo {
+	info := BuildInfo{}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.Date, _ = time.Parse(time.RFC3339, setting.Value)
+		}
+	}
+	return info
+}
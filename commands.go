@@ -0,0 +1,262 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// Path returns the names of the selected command and its parents, eg. []string{"db", "migrate"}.
+func (s SelectedCommand) Path() []string {
+	return strings.Fields(string(s))
+}
+
+// Is returns true if the selected command has the given path, eg. Is("db", "migrate").
+func (s SelectedCommand) Is(path ...string) bool {
+	selected := s.Path()
+	if len(selected) != len(path) {
+		return false
+	}
+	for i := range path {
+		if selected[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// A commandModule is the application module run when its command is selected.
+type commandModule struct {
+	cmd    *kingpin.CmdClause
+	module interface{}
+}
+
+// MainCommand adds a command that, when selected, runs module's Start(...) method instead of the application
+// module passed to Run.
+//
+// The module's flags are added to the command, and its providers and Configure() method are only installed and
+// called if the command is selected. Installed modules are started regardless of the selected command. If all
+// commands have modules, Run may be passed a nil application module.
+//
+// The returned clause can be used to further configure the command.
+func (a *Application) MainCommand(name, help string, module interface{}) *kingpin.CmdClause {
+	cmd := a.Command(name, help)
+	a.commands = append(a.commands, commandModule{cmd: cmd, module: module})
+	return cmd
+}
+
+// Mount adds a command that runs another Application, eg. to build a single binary from several tools.
+//
+// When the command is selected, the modules installed into sub are configured and started, as with
+// CommandModules(), and main is run, as with MainCommand(). The commands sub adds with MainCommand() and
+// CommandModules() are mounted as subcommands of the command. main may be nil if sub only has commands. Other
+// settings of sub, such as signal handling and timeouts, are not used.
+//
+// Only the name and help of sub and its commands are mounted, along with the flags of their modules. Run fails if
+// sub, or a command it adds with MainCommand(), has flags or arguments added with kingpin, or if sub has other
+// commands, eg. added with Command() or nested below a command. Add them to the returned clause instead.
+//
+// The returned clause can be used to further configure the command.
+func (a *Application) Mount(name string, sub *Application, main interface{}) *kingpin.CmdClause {
+	cmd := a.Command(name, sub.Model().Help)
+	a.mounts = append(a.mounts, mount{command: name, sub: sub})
+	a.commandModules = append(a.commandModules, commandModules{command: name, modules: sub.modules})
+	if main != nil {
+		a.commands = append(a.commands, commandModule{cmd: cmd, module: main})
+	}
+	for _, command := range sub.commands {
+		model := command.cmd.Model()
+		a.commands = append(a.commands, commandModule{cmd: cmd.Command(model.Name, model.Help), module: command.module})
+	}
+	for _, entry := range sub.commandModules {
+		a.commandModules = append(a.commandModules, commandModules{
+			command: name + " " + entry.command,
+			modules: entry.modules,
+		})
+	}
+	return cmd
+}
+
+// A mount is an Application mounted as a command with Mount().
+type mount struct {
+	command string
+	sub     *Application
+}
+
+// checkMounts returns an error if a mounted Application has flags, arguments or commands that Mount() did not copy.
+func (a *Application) checkMounts() error {
+	for _, mount := range a.mounts {
+		model := mount.sub.Model()
+		for _, flag := range model.Flags {
+			if !builtinFlags[flag.Name] {
+				return fmt.Errorf("mount %q: can't mount flag --%s", mount.command, flag.Name)
+			}
+		}
+		if len(model.Args) > 0 {
+			return fmt.Errorf("mount %q: can't mount argument <%s>", mount.command, model.Args[0].Name)
+		}
+		commands := map[string]bool{}
+		for _, command := range mount.sub.commands {
+			commands[command.cmd.Model().Name] = true
+		}
+		for _, command := range model.Commands {
+			switch {
+			case !commands[command.Name]:
+				return fmt.Errorf("mount %q: can't mount command %q without a module", mount.command, command.Name)
+			case len(command.Flags) > 0:
+				return fmt.Errorf("mount %q: can't mount flag --%s of command %q", mount.command, command.Flags[0].Name,
+					command.Name)
+			case len(command.Args) > 0:
+				return fmt.Errorf("mount %q: can't mount argument <%s> of command %q", mount.command,
+					command.Args[0].Name, command.Name)
+			case len(command.Commands) > 0:
+				return fmt.Errorf("mount %q: can't mount command %q of command %q", mount.command,
+					command.Commands[0].Name, command.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// commandModules are modules installed only when their command, or one of its subcommands, is selected.
+type commandModules struct {
+	command string
+	modules []interface{}
+}
+
+// CommandModules installs modules only when the given command, or one of its subcommands, is selected.
+//
+// "command" is the full path of the command, with the names of nested commands separated by spaces, eg.
+// "db migrate". The command must be added to the Application, eg. with Command() or MainCommand(), before Run is
+// called. The modules' flags are added to the command, and they are only configured and started if the command is
+// selected, in addition to the modules installed with Install(). A module already installed with Install() is
+// ignored.
+func (a *Application) CommandModules(command string, modules ...interface{}) *Application {
+	a.commandModules = append(a.commandModules, commandModules{command: command, modules: modules})
+	return a
+}
+
+// DefaultCommand sets the command that is selected if none is given on the command-line, eg. "serve".
+//
+// "command" is the full path of the command, with the names of nested commands separated by spaces. The command
+// must be added to the Application before Run is called. The default command is bound as the SelectedCommand, as
+// if it had been given.
+func (a *Application) DefaultCommand(command string) *Application {
+	a.defaultCommand = command
+	return a
+}
+
+// Alias adds alternative names that select a command, eg. to keep accepting a command's old name after renaming it.
+//
+// "command" is the full path of the command, with the names of nested commands separated by spaces. The command
+// must be added to the Application before Run is called. The SelectedCommand is always the command's own name,
+// whichever alias was given.
+func (a *Application) Alias(command string, aliases ...string) *Application {
+	a.aliases = append(a.aliases, commandAliases{command: command, aliases: aliases})
+	return a
+}
+
+// commandAliases are alternative names for a command.
+type commandAliases struct {
+	command string
+	aliases []string
+}
+
+// applyAliases adds aliases to their commands, unless already added by a previous Run.
+func (a *Application) applyAliases() error {
+	for _, entry := range a.aliases {
+		cmd, err := a.findCommand(entry.command)
+		if err != nil {
+			return fmt.Errorf("alias: %s", err)
+		}
+		existing := map[string]bool{}
+		for _, alias := range cmd.Model().Aliases {
+			existing[alias] = true
+		}
+		for _, alias := range entry.aliases {
+			if !existing[alias] {
+				cmd.Alias(alias)
+			}
+		}
+	}
+	return nil
+}
+
+// applyDefaultCommand marks the default command, and each of its parents, as the default.
+func (a *Application) applyDefaultCommand() error {
+	if a.defaultCommand == "" {
+		return nil
+	}
+	path := strings.Fields(a.defaultCommand)
+	for i := range path {
+		cmd, err := a.findCommand(strings.Join(path[:i+1], " "))
+		if err != nil {
+			return fmt.Errorf("default command: %s", err)
+		}
+		cmd.Default()
+	}
+	return nil
+}
+
+// findCommand returns the command with the given space-separated path.
+func (a *Application) findCommand(command string) (*kingpin.CmdClause, error) {
+	var cmd *kingpin.CmdClause
+	for i, name := range strings.Fields(command) {
+		if i == 0 {
+			cmd = a.GetCommand(name)
+		} else {
+			cmd = cmd.GetCommand(name)
+		}
+		if cmd == nil {
+			break
+		}
+	}
+	if cmd == nil {
+		return nil, fmt.Errorf("unknown command %q", command)
+	}
+	return cmd, nil
+}
+
+// prepareCommandModules resolves and checks command modules, and adds their flags to their commands, returning
+// them indexed by command, and in install order.
+func (a *Application) prepareCommandModules(injector *syncInjector, installed []interface{}) (
+	out map[string][]interface{}, all []interface{}, err error,
+) {
+	out = map[string][]interface{}{}
+	for _, entry := range a.commandModules {
+		cmd, err := a.findCommand(entry.command)
+		if err != nil {
+			return nil, nil, err
+		}
+		command := strings.Join(strings.Fields(entry.command), " ")
+		for _, module := range entry.modules {
+			module, err := resolveModule(injector, module)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isDuplicateModule(installed, module, false) || isDuplicateModule(out[command], module, false) {
+				continue
+			}
+			if err := a.checkModule(module); err != nil {
+				return nil, nil, err
+			}
+			if _, err := a.structFlags(cmd, module, ""); err != nil {
+				return nil, nil, err
+			}
+			out[command] = append(out[command], module)
+			all = append(all, module)
+		}
+	}
+	return out, all, nil
+}
+
+// selectedCommandModules returns the modules for the selected command and its parents, outermost first.
+func selectedCommandModules(modules map[string][]interface{}, command string) []interface{} {
+	out := []interface{}{}
+	path := strings.Fields(command)
+	for i := range path {
+		out = append(out, modules[strings.Join(path[:i+1], " ")]...)
+	}
+	return out
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// Shells GenerateCompletion can generate completion scripts for, keyed by their hidden flag.
+var completionShells = map[string]string{
+	"completion-script-bash": "bash",
+	"completion-script-zsh":  "zsh",
+}
+
+// addCompletionFlags adds the hidden --completion-script-bash and --completion-script-zsh flags, unless kingpin
+// already provides them.
+func (a *Application) addCompletionFlags() {
+	for _, flag := range []string{"completion-script-bash", "completion-script-zsh"} {
+		if a.GetFlag(flag) == nil {
+			a.Flag(flag, fmt.Sprintf("Generate completion script for %s.", completionShells[flag])).Hidden().Bool()
+		}
+	}
+}
+
+// Completion script templates, keyed by shell.
+var completionTemplates = map[string]string{
+	"bash": kingpin.BashCompletionTemplate,
+	"zsh":  kingpin.ZshCompletionTemplate,
+}
+
+// GenerateCompletion writes a shell completion script for the application to w, rendered with kingpin's template.
+//
+// The supported shells are "bash" and "zsh". The script completes commands and flags by running the application
+// with kingpin's hidden --completion-bash flag, which is parsed once all modules have been installed and configured,
+// so flags and commands added by modules are completed. The hidden --completion-script-bash and
+// --completion-script-zsh flags write the script to the output writer, and terminate the application, eg.
+//
+//		source <(myapp --completion-script-bash)
+func (a *Application) GenerateCompletion(shell string, w io.Writer) error {
+	template, ok := completionTemplates[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q", shell)
+	}
+	return a.renderTemplate(w, template)
+}
+
+// renderTemplate renders one of kingpin's usage templates for the application's commands and flags to w.
+func (a *Application) renderTemplate(w io.Writer, template string) error {
+	context, err := a.ParseContext(nil)
+	if err != nil {
+		return err
+	}
+	stderr := a.errorWriter
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	a.Application.Writers(w, stderr)
+	defer a.Application.Writers(a.outputWriter(), stderr)
+	return a.UsageForContextWithTemplate(&kingpin.UsageContext{Template: template}, context)
+}
+
+// completionShell returns the shell whose completion script is requested by args, if any.
+func completionShell(args []string) string {
+	for _, arg := range args {
+		if arg == "--" {
+			return ""
+		}
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		if shell, ok := completionShells[arg[2:]]; ok {
+			return shell
+		}
+	}
+	return ""
+}
+
+// outputWriter returns the writer usage information is written to.
+func (a *Application) outputWriter() io.Writer {
+	if a.output != nil {
+		return a.output
+	}
+	return os.Stdout
+}
@@ -0,0 +1,368 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile loads flag defaults from a YAML or JSON configuration file.
+//
+// The file contains a section for each module, keyed by its name (see ModuleName()), mapping the module's flag
+// names to values. For example, to configure the flag --http-bind of *httpserver.Module:
+//
+//		httpserver:
+//		  http-bind: ":8080"
+//
+// Values from the file take precedence over the defaults declared by modules, but are overridden by environment
+// variables and the command-line. The path may also be set at runtime with --config.
+//
+// The file may also contain named profiles, eg. per environment, under the reserved "profiles" key. Each profile
+// contains module sections, as above, which are applied over the rest of the file when the profile is selected
+// with --profile or an environment variable named after the application, eg. MYAPP_PROFILE:
+//
+//		httpserver:
+//		  http-bind: ":8080"
+//		profiles:
+//		  prod:
+//		    httpserver:
+//		      http-bind: ":80"
+//
+// Values therefore take precedence in the order: module defaults, the file, the selected profile, environment
+// variables, then the command-line. Calling ConfigFile again replaces the path.
+func (a *Application) ConfigFile(path string) *Application {
+	a.configFile = path
+	if flag := a.GetFlag("config"); flag != nil {
+		flag.Default(path)
+		return a
+	}
+	a.Flag("config", "Configuration file to load flag defaults from.").Default(path).String()
+	a.Flag("profile", "Configuration file profile to apply.").Envar(a.profileEnvar()).String()
+	return a
+}
+
+// profileEnvar returns the environment variable selecting a configuration file profile, eg. MYAPP_PROFILE, or
+// APP_PROFILE if the application has no name.
+func (a *Application) profileEnvar() string {
+	name := a.Name
+	if name == "" {
+		name = "app"
+	}
+	return EnvarName(name + "_profile")
+}
+
+// A ConfigSource loads configuration into modules from a backend such as Consul, etcd, Vault or AWS SSM.
+type ConfigSource interface {
+	// Load sets the fields of module, a pointer to an installed module or the application module, from the source.
+	//
+	// Fields the source has no configuration for should be left unchanged.
+	Load(module interface{}) error
+}
+
+// AddConfigSource adds a source of configuration for modules.
+//
+// Each source is passed each installed module and the application module in turn, once their flags have been
+// registered, and may set the module's flag fields. Sources are loaded in the order they were added, so later
+// sources take precedence over earlier ones, and all take precedence over the configuration file. Flags set with
+// SetFlag(), environment variables and the command-line take precedence over sources.
+func (a *Application) AddConfigSource(source ConfigSource) *Application {
+	a.configSources = append(a.configSources, source)
+	return a
+}
+
+// A structuredModule is a module whose fields have been added as flags, and the names of those flags.
+type structuredModule struct {
+	module interface{}
+	flags  []string
+}
+
+// loadConfigSources loads each configuration source into modules.
+//
+// The fields set by a source are detected from changes in their flag values, and become the defaults of their
+// flags, so that they are not overwritten when the command-line is parsed.
+func (a *Application) loadConfigSources() error {
+	for _, source := range a.configSources {
+		for _, entry := range a.structured {
+			before := make([]string, len(entry.flags))
+			for i, flag := range entry.flags {
+				before[i] = a.GetFlag(flag).Model().Value.String()
+			}
+			if err := source.Load(entry.module); err != nil {
+				return fmt.Errorf("%T.Load(%T): %s", source, entry.module, err)
+			}
+			for i, flag := range entry.flags {
+				clause := a.GetFlag(flag)
+				value := clause.Model().Value
+				if value.String() != before[i] {
+					clause.Default(flagDefaults(value)...)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// flagDefaults returns the current value of a flag as defaults.
+func flagDefaults(value kingpin.Value) []string {
+	if values, ok := dumpValue(value).([]string); ok {
+		return values
+	}
+	return []string{value.String()}
+}
+
+// PresetFields treats the flag fields of installed modules and the application module that are already set when
+// Run is called, ie. non-zero, as the defaults of their flags, eg. to configure modules in tests by populating
+// their structs rather than constructing arguments:
+//
+//		app.PresetFields(true).Install(&httpserver.Module{HTTPBind: ":0"})
+//
+// Preset fields take precedence over the configuration file and sources, but are overridden by SetFlag(),
+// environment variables and the command-line. As a field can't be distinguished from its zero value, a field can't
+// be preset to it. Modules are reused between runs, so fields set by a previous run are also treated as preset.
+func (a *Application) PresetFields(preset bool) *Application {
+	a.presetFields = preset
+	return a
+}
+
+// presets returns the values of the flags whose fields are preset, if PresetFields() is set.
+func (a *Application) presets() map[string][]string {
+	presets := map[string][]string{}
+	if !a.presetFields {
+		return presets
+	}
+	for _, entry := range a.structured {
+		for _, flag := range entry.flags {
+			value := a.GetFlag(flag).Model().Value
+			// Without a typed value, a zero value can't be detected.
+			getter, ok := value.(interface{ Get() interface{} })
+			if !ok {
+				continue
+			}
+			if current := getter.Get(); current != nil && !reflect.ValueOf(current).IsZero() {
+				presets[flag] = flagDefaults(value)
+			}
+		}
+	}
+	return presets
+}
+
+// applyPresets sets the defaults of flags whose fields are preset.
+func (a *Application) applyPresets(presets map[string][]string) {
+	for flag, values := range presets {
+		a.GetFlag(flag).Default(values...)
+	}
+}
+
+// SetFlag sets the default value of a flag programmatically, eg. in tests or when embedding the application.
+//
+// The flag need not exist yet, as the flags of modules are only registered when the application is run, but Run
+// returns an error if it still doesn't exist then. Multiple values may be given for repeatable flags. Values set
+// with SetFlag take precedence over the configuration file and sources, but are overridden by environment variables
+// and the command-line, eg.
+//
+//		app.SetFlag("http-bind", ":0")
+func (a *Application) SetFlag(name string, values ...string) *Application {
+	a.flagValues = append(a.flagValues, flagValue{name: name, values: values})
+	return a
+}
+
+// A flagValue is a flag default set with SetFlag().
+type flagValue struct {
+	name   string
+	values []string
+}
+
+// applyFlagValues sets the defaults of flags set with SetFlag().
+func (a *Application) applyFlagValues() error {
+	for _, value := range a.flagValues {
+		flag := a.GetFlag(value.name)
+		if flag == nil {
+			return fmt.Errorf("can't set unknown flag --%s", value.name)
+		}
+		flag.Default(value.values...)
+	}
+	return nil
+}
+
+// structModule adds the module's flags to kingpin, recording their names by module name in moduleFlags.
+//
+// If flags are namespaced, and namespace is true, the flags are prefixed with the module's namespace.
+func (a *Application) structModule(moduleFlags map[string][]string, module interface{}, namespace bool) error {
+	prefix := ""
+	if a.namespaceFlags && namespace {
+		prefix = flagNamespace(module) + "."
+	}
+	flags, err := a.structFlags(a.Application, module, prefix)
+	if err != nil {
+		return err
+	}
+	a.structured = append(a.structured, structuredModule{module: module, flags: flags})
+	name := ModuleName(module)
+	moduleFlags[name] = append(moduleFlags[name], flags...)
+	return nil
+}
+
+// loadConfigFile sets flag defaults from the configuration file, if any.
+func (a *Application) loadConfigFile(args []string, moduleFlags map[string][]string) error {
+	path := argValue(args, "config", a.configFile)
+	profile := argValue(args, "profile", os.Getenv(a.profileEnvar()))
+	if path == "" {
+		if profile != "" {
+			return fmt.Errorf("profile %q selected without a configuration file", profile)
+		}
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// JSON is a subset of YAML, so either may be used.
+	sections := map[string]map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	var file struct {
+		Profiles map[string]map[string]map[string]interface{} `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	delete(sections, "profiles")
+	if err := a.applyConfig(path, moduleFlags, sections); err != nil {
+		return err
+	}
+	if profile == "" {
+		return nil
+	}
+	sections, ok := file.Profiles[profile]
+	if !ok {
+		return fmt.Errorf("%s: unknown profile %q", path, profile)
+	}
+	return a.applyConfig(fmt.Sprintf("%s: profile %q", path, profile), moduleFlags, sections)
+}
+
+// applyConfig sets flag defaults from module sections of the configuration file, identified by source in errors.
+func (a *Application) applyConfig(
+	source string, moduleFlags map[string][]string, sections map[string]map[string]interface{},
+) error {
+	for module, values := range sections {
+		flags, ok := moduleFlags[module]
+		if !ok {
+			return fmt.Errorf("%s: unknown module %q", source, module)
+		}
+		for key, value := range values {
+			flag := configFlag(flags, key)
+			if flag == "" {
+				return fmt.Errorf("%s: unknown flag %q for module %q", source, key, module)
+			}
+			a.GetFlag(flag).Default(configValues(value)...)
+		}
+	}
+	return nil
+}
+
+// configFlag returns the name of the flag for a configuration key, which may be namespaced, or "" if there is none.
+func configFlag(flags []string, key string) string {
+	for _, flag := range flags {
+		if flag == key || strings.HasSuffix(flag, "."+key) {
+			return flag
+		}
+	}
+	return ""
+}
+
+// argValue returns the value of the flag --name if present in args, or value.
+//
+// This allows flags that determine how other flags are loaded to be read before the command-line is parsed.
+func argValue(args []string, name string, value string) string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			return value
+		case strings.HasPrefix(arg, "--"+name+"="):
+			value = strings.TrimPrefix(arg, "--"+name+"=")
+		case arg == "--"+name && i+1 < len(args):
+			value = args[i+1]
+		}
+	}
+	return value
+}
+
+// configValues converts a configuration value to flag values.
+func configValues(value interface{}) []string {
+	if values, ok := value.([]interface{}); ok {
+		out := make([]string, 0, len(values))
+		for _, v := range values {
+			out = append(out, fmt.Sprint(v))
+		}
+		return out
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// addConfigDumpFlag adds the hidden --config-dump flag.
+func (a *Application) addConfigDumpFlag() {
+	a.Flag("config-dump", "Print the effective configuration and exit.").Hidden().Bool()
+}
+
+// DumpConfig writes the effective configuration of each module to w, in the format of the configuration file (see
+// ConfigFile()), as either "yaml" or "json".
+//
+// Values are those of the module's flags once the configuration file, profile, environment variables and
+// command-line have been applied, so it is only complete once the command-line has been parsed. The values of
+// fields tagged with `secret:"true"`, eg. passwords, are replaced with "****". The hidden --config-dump flag writes
+// the configuration as YAML after parsing, then terminates the application.
+func (a *Application) DumpConfig(w io.Writer, format string) error {
+	sections := map[string]map[string]interface{}{}
+	for module, flags := range a.moduleFlags {
+		if len(flags) == 0 {
+			continue
+		}
+		section := map[string]interface{}{}
+		for _, name := range flags {
+			key := name
+			if a.namespaceFlags && strings.Contains(name, ".") {
+				key = strings.SplitN(name, ".", 2)[1]
+			}
+			if a.secrets[name] {
+				section[key] = redacted
+			} else {
+				section[key] = dumpValue(a.GetFlag(name).Model().Value)
+			}
+		}
+		sections[module] = section
+	}
+	var (
+		data []byte
+		err  error
+	)
+	switch format {
+	case "yaml":
+		data, err = yaml.Marshal(sections)
+	case "json":
+		data, err = json.MarshalIndent(sections, "", "  ")
+		data = append(data, '\n')
+	default:
+		return fmt.Errorf("unsupported configuration format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// dumpValue returns the value of a flag, typed if the kingpin value supports it.
+func dumpValue(value kingpin.Value) interface{} {
+	if getter, ok := value.(interface{ Get() interface{} }); ok {
+		return getter.Get()
+	}
+	return value.String()
+}
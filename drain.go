@@ -0,0 +1,58 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A Drainer module finishes in-flight work before it is stopped, eg. an HTTP server that stops accepting new
+// connections and waits for active requests to complete, so that an instance can be removed from a load balancer
+// without failing requests.
+//
+// Draining is distinct from Stop(...), which releases the module's resources.
+type Drainer interface {
+	// Drain is called for started modules, in the reverse of the order in which they started, after BeforeStop()
+	// and before any module is stopped. ctx is cancelled when the drain timeout, if any, expires.
+	Drain(ctx context.Context) error
+}
+
+// DrainTimeout bounds how long the Drain() methods of all modules may take in total.
+//
+// Once the timeout expires, the context passed to Drain() is cancelled, and modules are stopped as soon as their
+// Drain() methods return.
+func (a *Application) DrainTimeout(timeout time.Duration) *Application {
+	a.drainTimeout = timeout
+	return a
+}
+
+// drain calls the Drain() method of each module, in order, collecting any errors.
+func (a *Application) drain(modules []interface{}) Errors {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if a.drainTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), a.drainTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	errs := Errors{}
+	for _, module := range modules {
+		drainer, ok := module.(Drainer)
+		if !ok {
+			continue
+		}
+		err := a.guard(module, "Drain", func() error {
+			if err := drainer.Drain(ctx); err != nil {
+				return fmt.Errorf("%T.Drain(): %s", module, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
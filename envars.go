@@ -0,0 +1,74 @@
+package app
+
+import (
+	"strings"
+	"unicode"
+)
+
+// EnvarPrefix sets an environment variable for every flag that does not already declare one, named by prefixing
+// the flag name with prefix and an underscore, then upper-casing and replacing non-alphanumeric characters with
+// underscores. eg. with the prefix "myapp" the flag --http-bind may be set with MYAPP_HTTP_BIND.
+func (a *Application) EnvarPrefix(prefix string) *Application {
+	return a.Envars(func(flag string) string {
+		return EnvarName(prefix + "_" + flag)
+	})
+}
+
+// Envars sets an environment variable for every flag that does not already declare one, named by calling envar
+// with the flag name. Flags for which envar returns an empty string are skipped.
+//
+// Environment variables are applied to the flags of installed modules and top-level commands before the
+// command-line is parsed.
+func (a *Application) Envars(envar func(flag string) string) *Application {
+	a.envar = envar
+	return a
+}
+
+// EnvarName converts s to an environment variable name by upper-casing it and replacing non-alphanumeric
+// characters with underscores.
+func EnvarName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, s)
+}
+
+// Flags that are never set from the environment.
+var builtinFlags = map[string]bool{
+	"help":      true,
+	"help-long": true,
+	"help-man":  true,
+	"version":   true,
+
+	"config-dump":            true,
+	"completion-script-bash": true,
+	"completion-script-zsh":  true,
+}
+
+// applyEnvars sets the environment variable of every flag without one.
+func (a *Application) applyEnvars() {
+	if a.envar == nil {
+		return
+	}
+	model := a.Model()
+	for _, flag := range model.Flags {
+		if flag.Envar != "" || builtinFlags[flag.Name] {
+			continue
+		}
+		if envar := a.envar(flag.Name); envar != "" {
+			a.GetFlag(flag.Name).Envar(envar)
+		}
+	}
+	for _, cmd := range model.Commands {
+		for _, flag := range cmd.Flags {
+			if flag.Envar != "" {
+				continue
+			}
+			if envar := a.envar(flag.Name); envar != "" {
+				a.GetCommand(cmd.Name).GetFlag(flag.Name).Envar(envar)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoStartMethod is returned, wrapped, from Run if the application module has no Start(...) method.
+var ErrNoStartMethod = errors.New("no Start(...) method")
+
+// ConfigureError is returned from Run if a module's Configure() method fails.
+type ConfigureError struct {
+	Module interface{}
+	Err    error
+}
+
+func (e ConfigureError) Error() string {
+	return fmt.Sprintf("%T.Configure(): %s", e.Module, e.Err)
+}
+
+// Unwrap returns the error returned by Configure().
+func (e ConfigureError) Unwrap() error {
+	return e.Err
+}
+
+// StartError is returned from Run if a module fails to start, including the application module.
+type StartError struct {
+	Module interface{}
+	Err    error
+}
+
+func (e StartError) Error() string {
+	return fmt.Sprintf("%T.Start(): %s", e.Module, e.Err)
+}
+
+// Unwrap returns the error returned by the module's Start(...) method, or why it failed to start.
+func (e StartError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned from Run if the command-line can't be parsed.
+//
+// Its message is that of the underlying error, which is returned by Unwrap.
+type ParseError struct {
+	Err error
+}
+
+func (e ParseError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error returned by kingpin.
+func (e ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Errors is a collection of errors, such as those returned by multiple Stop() methods.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors, so that errors.Is and errors.As match any of them.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Err returns nil if there are no errors, the error itself if there is only one, or the collection.
+func (e Errors) Err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	default:
+		return e
+	}
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the type of a lifecycle Event.
+type EventType int
+
+// Lifecycle event types.
+const (
+	// EventInstalled is emitted when a module's providers have been installed into the injector.
+	EventInstalled EventType = iota
+	// EventConfigured is emitted when a module's Configure() method returns.
+	EventConfigured
+	// EventStarted is emitted when a module's Start(...) method returns.
+	EventStarted
+	// EventStopped is emitted when a module's Stop(...) method returns.
+	EventStopped
+	// EventErrored is emitted when any of the above fail.
+	EventErrored
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventInstalled:
+		return "installed"
+	case EventConfigured:
+		return "configured"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	case EventErrored:
+		return "errored"
+	}
+	return fmt.Sprintf("EventType(%d)", int(e))
+}
+
+// Event describes a module lifecycle transition.
+type Event struct {
+	Type EventType
+	// Module is the name of the module's type, eg. "*mongo.Module".
+	Module string
+	// Elapsed is the time taken by the transition.
+	Elapsed time.Duration
+	// Err is the error for EventErrored.
+	Err error
+}
+
+// OnEvent sets a function to be called on each module lifecycle transition.
+//
+// Calls are serialised, so the function need not be safe for concurrent use even when modules are started
+// concurrently, but it should return promptly.
+func (a *Application) OnEvent(f func(Event)) *Application {
+	a.onEvent = f
+	return a
+}
+
+// The number of events buffered by the channel returned from Events().
+const eventBuffer = 256
+
+// Events returns a channel receiving each module lifecycle transition, eg. to render startup progress in a UI.
+//
+// Every call returns the same channel, which is never closed. Transitions before the first call are not sent. The
+// channel is buffered and events are sent without blocking, so that a slow consumer never stalls the application:
+// if the buffer is full, the event is dropped.
+func (a *Application) Events() <-chan Event {
+	a.eventLock.Lock()
+	defer a.eventLock.Unlock()
+	if a.events == nil {
+		a.events = make(chan Event, eventBuffer)
+	}
+	return a.events
+}
+
+func (a *Application) emit(eventType EventType, module interface{}, start time.Time, err error) {
+	event := Event{
+		Type:    eventType,
+		Module:  fmt.Sprintf("%T", module),
+		Elapsed: time.Since(start),
+		Err:     err,
+	}
+	if err != nil {
+		a.log().Debugf("%s %s after %s: %s", event.Module, event.Type, event.Elapsed, err)
+	} else {
+		a.log().Debugf("%s %s in %s", event.Module, event.Type, event.Elapsed)
+	}
+	a.eventLock.Lock()
+	a.recordStat(eventType, module, event.Elapsed)
+	if a.events != nil {
+		select {
+		case a.events <- event:
+		default:
+		}
+	}
+	a.eventLock.Unlock()
+	// The callback is called without eventLock held, so that it may call eg. Stats().
+	if a.onEvent != nil {
+		a.callbackLock.Lock()
+		defer a.callbackLock.Unlock()
+		a.onEvent(event)
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// A flagGroup is a kingpin application or command that flags can be added to.
+type flagGroup interface {
+	Flag(name, help string) *kingpin.Clause
+	GetFlag(name string) *kingpin.Clause
+	Struct(v interface{}) error
+}
+
+// beginFlags is called at the start of each Run, making the flags added for modules by the previous Run available
+// to be reused.
+func (a *Application) beginFlags() {
+	a.reusableFlags = a.moduleClauses
+	a.moduleClauses = map[*kingpin.Clause]bool{}
+}
+
+// reuseFlag returns the flag --name added to group by the previous Run, if any, claiming it for this Run.
+//
+// As kingpin can't remove flags, flags are reused rather than added again, so that an Application can be run
+// repeatedly. A flag can only be claimed once per Run, so that conflicting flags are still reported by kingpin.
+func (a *Application) reuseFlag(group flagGroup, name string) *kingpin.Clause {
+	clause := group.GetFlag(name)
+	if clause == nil || !a.reusableFlags[clause] {
+		return nil
+	}
+	delete(a.reusableFlags, clause)
+	a.moduleClauses[clause] = true
+	return clause
+}
+
+// moduleFlag adds the flag --name for a module to group, or reuses it from the previous Run.
+func (a *Application) moduleFlag(group flagGroup, name, help string) *kingpin.Clause {
+	if clause := a.reuseFlag(group, name); clause != nil {
+		return clause
+	}
+	clause := group.Flag(name, help)
+	a.moduleClauses[clause] = true
+	return clause
+}
+
+// structFlags adds the flags of a module's fields to group, prefixed with prefix, returning their names, and
+// recording which of them are secret.
+//
+// The flags are first parsed from the module by a scratch kingpin application. Flags added by the previous Run are
+// rebound to the module's fields, with their original defaults, rather than added again.
+func (a *Application) structFlags(group flagGroup, module interface{}, prefix string) ([]string, error) {
+	scratch := kingpin.New("", "")
+	if err := scratch.Struct(module); err != nil {
+		return nil, err
+	}
+	secrets, err := secretFlags(module)
+	if err != nil {
+		return nil, err
+	}
+	flags := []*kingpin.ClauseModel{}
+	names := []string{}
+	reused := false
+	for _, flag := range scratch.Model().Flags {
+		if builtinFlags[flag.Name] {
+			continue
+		}
+		flags = append(flags, flag)
+		names = append(names, prefix+flag.Name)
+		if secrets[flag.Name] {
+			a.secrets[prefix+flag.Name] = true
+		}
+		if clause := group.GetFlag(prefix + flag.Name); clause != nil && a.reusableFlags[clause] {
+			reused = true
+		}
+	}
+	// Unprefixed flags are added by kingpin directly when first run, preserving everything it supports.
+	if prefix == "" && !reused {
+		if err := group.Struct(module); err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			a.moduleClauses[group.GetFlag(name)] = true
+		}
+		return names, nil
+	}
+	for _, flag := range flags {
+		clause := a.reuseFlag(group, prefix+flag.Name)
+		if clause == nil {
+			clause = a.moduleFlag(group, prefix+flag.Name, flag.Help).PlaceHolder(flag.PlaceHolder)
+			if flag.Envar != "" {
+				clause.Envar(flag.Envar)
+			}
+			if flag.Hidden {
+				clause.Hidden()
+			}
+			if flag.Required {
+				clause.Required()
+			}
+		}
+		clause.Default(flag.Default...)
+		clause.SetValue(flag.Value)
+	}
+	return names, nil
+}
@@ -3,18 +3,34 @@ package app
 import (
 	"os"
 	"path/filepath"
-
-	"gopkg.in/alecthomas/kingpin.v3-unstable"
 )
 
 var (
 	// App is the default application instance.
-	App = New(filepath.Base(os.Args[0]), "")
+	App = New(filepath.Base(os.Args[0]))
 )
 
-// Run the given module using the global Application instance.
+// Run the given module using the global Application instance, terminating the application if it fails.
+//
+// If the error is, or contains, an ExitError, the application exits with its code instead.
 func Run(module interface{}) {
-	FatalIfError(App.Run(module), "")
+	err := RunE(module)
+	if _, ok := err.(TerminatedError); ok {
+		return
+	}
+	if exit, ok := exitError(err); ok {
+		if exit.Err != nil {
+			Errorf("%s", err)
+		}
+		App.handleTerminate(exit.Code)
+		return
+	}
+	FatalIfError(err, "")
+}
+
+// RunE runs the given module using the global Application instance, returning any error.
+func RunE(module interface{}) error {
+	return App.Run(module)
 }
 
 // Help sets the global Application help.
@@ -27,9 +43,9 @@ func Install(modules ...interface{}) *Application {
 	return App.Install(modules...)
 }
 
-// Errorf prints a consistent error message to stderr.
+// Errorf prints a consistent error message to the global Application's error writer, stderr by default.
 func Errorf(format string, args ...interface{}) {
-	kingpin.Errorf(format, args...)
+	App.Errorf(format, args...)
 }
 
 // Fatalf prints an error message to stderr and terminates the application
@@ -44,7 +60,8 @@ func FatalUsage(format string, args ...interface{}) {
 	App.FatalUsage(format, args...)
 }
 
-// FatalIfError checks if err is present and if so, terminates with the given message.
+// FatalIfError checks if err is present and if so, terminates with the given message, followed by the error. If
+// format is empty, only the error is printed.
 func FatalIfError(err error, format string, args ...interface{}) {
 	App.FatalIfError(err, format, args...)
 }
@@ -0,0 +1,404 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// providedTypes returns the types a module provides to other modules.
+//
+// These are the types provided by its Provide*() methods, or exported by a Scoped module, and the non-error types
+// returned by its Start() method or EntryPoint().
+func providedTypes(module interface{}) []reflect.Type {
+	scoped, ok := module.(Scoped)
+	if !ok {
+		return moduleTypes(module)
+	}
+	return append(append([]reflect.Type{}, scoped.Exports()...), startedTypes(module)...)
+}
+
+// startedTypes returns the non-error types returned by a module's Start() method, or its EntryPoint().
+func startedTypes(module interface{}) []reflect.Type {
+	out := []reflect.Type{}
+	if start := startMethod(module); start.IsValid() {
+		for j := 0; j < start.Type().NumOut(); j++ {
+			if t := start.Type().Out(j); t != errorType {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// moduleTypes returns the types provided by a module's Provide*() methods, and the non-error types returned by
+// its Start() method.
+func moduleTypes(module interface{}) []reflect.Type {
+	out := []reflect.Type{}
+	mt := reflect.TypeOf(module)
+	for i := 0; i < mt.NumMethod(); i++ {
+		method := mt.Method(i)
+		if strings.HasPrefix(method.Name, "Provide") && method.Type.NumOut() > 0 {
+			out = append(out, method.Type.Out(0))
+		}
+	}
+	return append(out, startedTypes(module)...)
+}
+
+// A requirement is a type injected into a module method.
+type requirement struct {
+	method string
+	t      reflect.Type
+}
+
+// requirements returns the types injected into a module's Provide*(), Start() and Stop() methods, and its Eager
+// types.
+func requirements(module interface{}) []requirement {
+	out := []requirement{}
+	mt := reflect.TypeOf(module)
+	for i := 0; i < mt.NumMethod(); i++ {
+		method := mt.Method(i)
+		if !strings.HasPrefix(method.Name, "Provide") && method.Name != "Stop" {
+			continue
+		}
+		// Skip the receiver.
+		for j := 1; j < method.Type.NumIn(); j++ {
+			out = append(out, requirement{method.Name, method.Type.In(j)})
+		}
+	}
+	if start := startMethod(module); start.IsValid() {
+		name := "Start"
+		if _, ok := module.(EntryPoint); ok {
+			name = "EntryPoint"
+		}
+		for j := 0; j < start.Type().NumIn(); j++ {
+			out = append(out, requirement{name, start.Type().In(j)})
+		}
+	}
+	if eager, ok := module.(Eager); ok {
+		for _, t := range eager.Eager() {
+			out = append(out, requirement{"Eager", t})
+		}
+	}
+	return out
+}
+
+// requiredTypes returns the types injected into a module's Provide*(), Start() and Stop() methods, and its Eager
+// types.
+func requiredTypes(module interface{}) []reflect.Type {
+	out := []reflect.Type{}
+	for _, r := range requirements(module) {
+		out = append(out, r.t)
+	}
+	return out
+}
+
+// A provider is a module's Provide*() method.
+type provider struct {
+	module   interface{}
+	method   string
+	provides reflect.Type
+	requires []reflect.Type
+}
+
+func (p provider) String() string {
+	return fmt.Sprintf("%s (%T.%s())", p.provides, p.module, p.method)
+}
+
+// moduleProviders returns the Provide*() methods of the modules, in install order.
+func moduleProviders(modules []interface{}) []provider {
+	out := []provider{}
+	for _, module := range modules {
+		mt := reflect.TypeOf(module)
+		for i := 0; i < mt.NumMethod(); i++ {
+			method := mt.Method(i)
+			if !strings.HasPrefix(method.Name, "Provide") || method.Type.NumOut() == 0 {
+				continue
+			}
+			p := provider{module: module, method: method.Name, provides: method.Type.Out(0)}
+			// Skip the receiver.
+			for j := 1; j < method.Type.NumIn(); j++ {
+				p.requires = append(p.requires, method.Type.In(j))
+			}
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// findProviderCycle returns an error describing the first cycle between the modules' Provide*() methods, if any.
+func findProviderCycle(modules []interface{}) error {
+	providers := moduleProviders(modules)
+	byType := map[reflect.Type][]int{}
+	for i, p := range providers {
+		byType[p.provides] = append(byType[p.provides], i)
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(providers))
+	path := []int{}
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := []string{}
+			for j := len(path) - 1; j >= 0; j-- {
+				if path[j] == i {
+					for _, k := range path[j:] {
+						cycle = append(cycle, providers[k].String())
+					}
+					break
+				}
+			}
+			cycle = append(cycle, providers[i].provides.String())
+			return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+		state[i] = visiting
+		path = append(path, i)
+		for _, t := range providers[i].requires {
+			for _, j := range byType[t] {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range providers {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Order returns the installed modules in dependency order.
+//
+// A module depends on another module if any of its Provide*(), Start() or Stop() methods require a type provided
+// by that module, either from a Provide*() method or returned from its Start() method, or if either module declares
+// an ordering constraint with Before, After or DependsOn. Modules are started in this order, and stopped in reverse,
+// so a module's Stop() method is called before those of the modules providing its arguments. Modules with no
+// dependency relationship retain their install order.
+func (a *Application) Order() ([]interface{}, error) {
+	return dependencyOrder(a.installedModules())
+}
+
+// A Before module is started before installed modules of the given types, and stopped after them.
+//
+// This can be used to order modules without a data dependency, eg. to initialise logging before other modules.
+type Before interface {
+	Before() []reflect.Type
+}
+
+// An After module is started after installed modules of the given types, and stopped before them.
+type After interface {
+	After() []reflect.Type
+}
+
+// A DependsOn module is started after the given modules, and stopped before them, eg. to start after a database
+// migration module that it doesn't inject anything from.
+//
+// Each dependency is either an installed module instance, or the reflect.Type of installed modules. Unlike After,
+// Order returns an error if a dependency is not installed.
+type DependsOn interface {
+	DependsOn() []interface{}
+}
+
+// moduleDependencies returns, for each module, the indices of the other modules it depends on, either via
+// injection or Before/After/DependsOn constraints.
+//
+// Dependencies that are not in modules are ignored.
+func moduleDependencies(modules []interface{}) []map[int]bool {
+	providers := map[reflect.Type][]int{}
+	byType := map[reflect.Type][]int{}
+	for i, module := range modules {
+		for _, t := range providedTypes(module) {
+			providers[t] = append(providers[t], i)
+		}
+		byType[reflect.TypeOf(module)] = append(byType[reflect.TypeOf(module)], i)
+	}
+	dependencies := make([]map[int]bool, len(modules))
+	for i := range modules {
+		dependencies[i] = map[int]bool{}
+	}
+	for i, module := range modules {
+		for _, t := range requiredTypes(module) {
+			for _, j := range providers[t] {
+				if j != i {
+					dependencies[i][j] = true
+				}
+			}
+		}
+		if after, ok := module.(After); ok {
+			for _, t := range after.After() {
+				for _, j := range byType[t] {
+					if j != i {
+						dependencies[i][j] = true
+					}
+				}
+			}
+		}
+		if before, ok := module.(Before); ok {
+			for _, t := range before.Before() {
+				for _, j := range byType[t] {
+					if j != i {
+						dependencies[j][i] = true
+					}
+				}
+			}
+		}
+		if dependsOn, ok := module.(DependsOn); ok {
+			for _, dependency := range dependsOn.DependsOn() {
+				for _, j := range dependencyIndices(modules, byType, dependency) {
+					if j != i {
+						dependencies[i][j] = true
+					}
+				}
+			}
+		}
+	}
+	return dependencies
+}
+
+// dependencyIndices returns the indices of the modules matching a dependency returned by DependsOn(), either a
+// module instance or the reflect.Type of modules.
+func dependencyIndices(modules []interface{}, byType map[reflect.Type][]int, dependency interface{}) []int {
+	if t, ok := dependency.(reflect.Type); ok {
+		return byType[t]
+	}
+	for i, module := range modules {
+		if module == dependency {
+			return []int{i}
+		}
+	}
+	return nil
+}
+
+// checkDependsOn returns an error if a dependency returned by a DependsOn module's DependsOn() is not installed.
+func checkDependsOn(modules []interface{}) error {
+	byType := map[reflect.Type][]int{}
+	for i, module := range modules {
+		byType[reflect.TypeOf(module)] = append(byType[reflect.TypeOf(module)], i)
+	}
+	for _, module := range modules {
+		dependsOn, ok := module.(DependsOn)
+		if !ok {
+			continue
+		}
+		for _, dependency := range dependsOn.DependsOn() {
+			if len(dependencyIndices(modules, byType, dependency)) > 0 {
+				continue
+			}
+			if t, ok := dependency.(reflect.Type); ok {
+				return fmt.Errorf("%T depends on %s, but no such module is installed", module, t)
+			}
+			return fmt.Errorf("%T depends on %T, but it is not installed", module, dependency)
+		}
+	}
+	return nil
+}
+
+// hasOrderingConstraints returns true if any of the modules implement Before, After or DependsOn.
+func hasOrderingConstraints(modules []interface{}) bool {
+	for _, module := range modules {
+		_, before := module.(Before)
+		_, after := module.(After)
+		_, dependsOn := module.(DependsOn)
+		if before || after || dependsOn {
+			return true
+		}
+	}
+	return false
+}
+
+func dependencyOrder(modules []interface{}) ([]interface{}, error) {
+	if err := checkDependsOn(modules); err != nil {
+		return nil, err
+	}
+	dependencies := moduleDependencies(modules)
+	// Stable topological sort, always selecting the earliest installed module with no outstanding dependencies.
+	out := make([]interface{}, 0, len(modules))
+	done := make([]bool, len(modules))
+	for len(out) < len(modules) {
+		next := -1
+		for i := range modules {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for j := range dependencies[i] {
+				if !done[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			remaining := []string{}
+			for i, module := range modules {
+				if !done[i] {
+					remaining = append(remaining, fmt.Sprintf("%T", module))
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between modules %s", strings.Join(remaining, ", "))
+		}
+		done[next] = true
+		out = append(out, modules[next])
+	}
+	return out, nil
+}
+
+// Graph writes the modules and the types they provide and require to w, as a Graphviz DOT graph.
+//
+// Modules are drawn as boxes labelled with their type, and types as ellipses, with solid edges from each module to
+// the types it provides, and dashed edges from each type to the modules that require it. Each module is a separate
+// node, identified by its name (see ModuleName()) and install order, even if several have the same type. If the
+// application has not yet run, the modules are configured as for Validate().
+func (a *Application) Graph(w io.Writer) error {
+	modules := a.installed
+	if modules == nil {
+		var err error
+		_, modules, err = a.configureScratch()
+		if err != nil {
+			return err
+		}
+	}
+	lines := []string{"digraph app {"}
+	seen := map[reflect.Type]bool{}
+	typeNode := func(t reflect.Type) string {
+		if !seen[t] {
+			seen[t] = true
+			lines = append(lines, fmt.Sprintf("  %q [shape=ellipse];", t.String()))
+		}
+		return fmt.Sprintf("%q", t.String())
+	}
+	for i, module := range modules {
+		node := fmt.Sprintf("%q", fmt.Sprintf("%s.%d", ModuleName(module), i))
+		lines = append(lines, fmt.Sprintf("  %s [shape=box, label=%q];", node, fmt.Sprintf("%T", module)))
+		for _, t := range providedTypes(module) {
+			lines = append(lines, fmt.Sprintf("  %s -> %s;", node, typeNode(t)))
+		}
+		required := map[reflect.Type]bool{}
+		for _, t := range requiredTypes(module) {
+			if !required[t] {
+				required[t] = true
+				lines = append(lines, fmt.Sprintf("  %s -> %s [style=dashed];", typeNode(t), node))
+			}
+		}
+	}
+	lines = append(lines, "}")
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"time"
+)
+
+// The default maximum time shutdown is held off by a ShutdownGuard.
+const defaultHoldTimeout = 30 * time.Second
+
+// ShutdownGuard is available for injection, allowing a module to hold off stopping modules while it completes
+// critical work, eg. a stateful worker committing a transaction.
+//
+// Once shutdown begins, and the application module and any runners have returned, Run waits until every Hold() has
+// been released before stopping any module. This is enforced with a hard deadline (see MaxShutdownHold()), after
+// which modules are stopped regardless.
+type ShutdownGuard interface {
+	// Hold off stopping modules until Release() is called.
+	Hold()
+	// Release a Hold().
+	Release()
+}
+
+// shutdownGuard implements ShutdownGuard.
+type shutdownGuard struct {
+	holds *tasks
+}
+
+func (s shutdownGuard) Hold()    { s.holds.Add(1) }
+func (s shutdownGuard) Release() { s.holds.Done() }
+
+// MaxShutdownHold bounds how long a ShutdownGuard may hold off stopping modules. The default is 30 seconds.
+func (a *Application) MaxShutdownHold(timeout time.Duration) *Application {
+	a.holdTimeout = timeout
+	return a
+}
+
+// waitForHolds waits until every ShutdownGuard hold has been released, or the hold timeout expires.
+func (a *Application) waitForHolds(holds *tasks) {
+	timeout := a.holdTimeout
+	if timeout == 0 {
+		timeout = defaultHoldTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	holds.wait(ctx)
+	if ctx.Err() != nil {
+		a.log().Warnf("shutdown still held after %s, stopping anyway", timeout)
+	}
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"fmt"
+)
+
+// A HealthChecker module can report its health.
+type HealthChecker interface {
+	// HealthCheck returns an error if the module is unhealthy.
+	HealthCheck(ctx context.Context) error
+}
+
+// A LivenessChecker module can report whether it is alive. A module that is not alive can't recover, and the
+// application should be restarted, eg. by a Kubernetes liveness probe.
+type LivenessChecker interface {
+	// LivenessCheck returns an error if the module is not alive.
+	LivenessCheck(ctx context.Context) error
+}
+
+// A ReadinessChecker module can report whether it is ready to serve. A module that is not ready may become ready
+// again, eg. once a dependency recovers, so the application should only stop receiving traffic, eg. by failing a
+// Kubernetes readiness probe.
+type ReadinessChecker interface {
+	// ReadinessCheck returns an error if the module is not ready.
+	ReadinessCheck(ctx context.Context) error
+}
+
+// Health checks the health of all modules implementing HealthChecker.
+//
+// The returned error, if any, is an Errors containing the error from each unhealthy module.
+func (a *Application) Health(ctx context.Context) error {
+	return a.checkModules(func(module interface{}) error {
+		if checker, ok := module.(HealthChecker); ok {
+			return checker.HealthCheck(ctx)
+		}
+		return nil
+	})
+}
+
+// Liveness checks the liveness of all modules implementing LivenessChecker.
+//
+// The returned error, if any, is an Errors containing the error from each module that is not alive. Unlike
+// Readiness(), a failure indicates the application should be restarted.
+func (a *Application) Liveness(ctx context.Context) error {
+	return a.checkModules(func(module interface{}) error {
+		if checker, ok := module.(LivenessChecker); ok {
+			return checker.LivenessCheck(ctx)
+		}
+		return nil
+	})
+}
+
+// Readiness checks the readiness of all modules implementing ReadinessChecker.
+//
+// The returned error, if any, is an Errors containing the error from each module that is not ready. Unlike
+// Liveness(), a failure indicates the application should only be taken out of rotation.
+func (a *Application) Readiness(ctx context.Context) error {
+	return a.checkModules(func(module interface{}) error {
+		if checker, ok := module.(ReadinessChecker); ok {
+			return checker.ReadinessCheck(ctx)
+		}
+		return nil
+	})
+}
+
+// checkModules calls check for each module, returning an Errors containing each module's error, if any.
+//
+// While the application module is running, the started modules, including command modules, and the application
+// module are checked. Otherwise the installed modules are.
+func (a *Application) checkModules(check func(module interface{}) error) error {
+	errs := Errors{}
+	for _, module := range a.checkedModules() {
+		if err := check(module); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %s", module, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// checkedModules returns the modules checked by checkModules().
+func (a *Application) checkedModules() []interface{} {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.lifecycle == nil {
+		return a.installedModules()
+	}
+	modules := a.lifecycle.started()
+	if a.main != nil {
+		modules = append(modules, a.main)
+	}
+	return modules
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"fmt"
+)
+
+// A BeforeStarter module is called after modules are ordered, but before any module is started.
+type BeforeStarter interface {
+	// BeforeStart is called in dependency order. Returning an error aborts the application before any module is
+	// started.
+	BeforeStart() error
+}
+
+// An AfterStarter module is called once all installed modules have started, before the application module's
+// Start(...) method is called, eg. to mark the module as ready.
+type AfterStarter interface {
+	// AfterStart is called in dependency order. Returning an error shuts the application down.
+	AfterStart() error
+}
+
+// A BeforeStopper module is called once the application module's Start(...) method has returned, before any
+// module is stopped, eg. to mark the module as no longer ready.
+type BeforeStopper interface {
+	// BeforeStop is called for started modules, in the reverse of the order in which they started.
+	BeforeStop() error
+}
+
+// An AfterStopper module is called once all started modules have been stopped.
+type AfterStopper interface {
+	// AfterStop is called for started modules, in the reverse of the order in which they started.
+	AfterStop() error
+}
+
+// phaseHook returns the module's hook for the given phase, or nil.
+func phaseHook(module interface{}, phase string) func() error {
+	switch phase {
+	case "BeforeStart":
+		if hook, ok := module.(BeforeStarter); ok {
+			return hook.BeforeStart
+		}
+	case "AfterStart":
+		if hook, ok := module.(AfterStarter); ok {
+			return hook.AfterStart
+		}
+	case "BeforeStop":
+		if hook, ok := module.(BeforeStopper); ok {
+			return hook.BeforeStop
+		}
+	case "AfterStop":
+		if hook, ok := module.(AfterStopper); ok {
+			return hook.AfterStop
+		}
+	}
+	return nil
+}
+
+// callPhase calls the hook for the given phase of each module, in order.
+//
+// Unless all is true, it stops at the first error.
+func (a *Application) callPhase(modules []interface{}, phase string, all bool) Errors {
+	errs := Errors{}
+	for _, module := range modules {
+		hook := phaseHook(module, phase)
+		if hook == nil {
+			continue
+		}
+		err := a.guard(module, phase, func() error {
+			if err := hook(); err != nil {
+				return fmt.Errorf("%T.%s(): %s", module, phase, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+			if !all {
+				break
+			}
+		}
+	}
+	return errs
+}
+
+// reversed returns modules in reverse order.
+func reversed(modules []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(modules))
+	for i := len(modules) - 1; i >= 0; i-- {
+		out = append(out, modules[i])
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+// Package httpserver provides a module serving HTTP routes registered by other modules with app.Router.
+//
+//		app.Install(&httpserver.Module{}).Run(&Application{})
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/app"
+)
+
+// Module serves HTTP on --http-bind, routing requests with the app.Router it provides.
+//
+// The address is bound when the module starts, so that a failure to bind fails Run, and requests are served once
+// every module has started. On shutdown the server stops accepting connections and waits for active requests, up
+// to --http-shutdown-timeout, after which their connections are closed.
+type Module struct {
+	HTTPBind            string        `help:"Bind address for HTTP server." default:":8090"`
+	HTTPShutdownTimeout time.Duration `help:"How long to wait for active requests on shutdown." default:"30s"`
+
+	mux      *http.ServeMux
+	lock     sync.Mutex
+	listener net.Listener
+	// Set once serving begins, after which the server closes the listener.
+	serving bool
+}
+
+// Configure creates the mux that requests are routed with.
+func (m *Module) Configure(binder app.Binder) error {
+	m.mux = http.NewServeMux()
+	return nil
+}
+
+// ProvideRouter provides the mux that requests are routed with.
+func (m *Module) ProvideRouter() app.Router {
+	return m.mux
+}
+
+// Start binds the address, so that requests can be served once every module has started.
+func (m *Module) Start() error {
+	listener, err := net.Listen("tcp", m.HTTPBind)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.listener = listener
+	m.serving = false
+	return nil
+}
+
+// Stop closes the listener if serving never began, eg. as another module failed to start.
+func (m *Module) Stop() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.listener == nil || m.serving {
+		return nil
+	}
+	return m.listener.Close()
+}
+
+// ProvideRunnerSequence serves HTTP until the application is shut down.
+func (m *Module) ProvideRunnerSequence() []app.Runner {
+	return []app.Runner{m.serve}
+}
+
+// Addr returns the address the server is bound to, eg. to find the port when bound to ":0". It is nil until the
+// module has started.
+func (m *Module) Addr() net.Addr {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.listener == nil {
+		return nil
+	}
+	return m.listener.Addr()
+}
+
+func (m *Module) serve(ctx context.Context) error {
+	m.lock.Lock()
+	listener := m.listener
+	m.serving = true
+	m.lock.Unlock()
+	server := &http.Server{Handler: m.mux}
+	errs := make(chan error, 1)
+	go func() { errs <- server.Serve(listener) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.HTTPShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return err
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/alecthomas/app"
+	"github.com/alecthomas/app/apptest"
+)
+
+type testRoutesModule struct{}
+
+func (t *testRoutesModule) Start(router app.Router) {
+	router.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello world")
+	})
+}
+
+func TestModule(t *testing.T) {
+	server := &Module{}
+	h := apptest.New(server, &testRoutesModule{}).Args("--http-bind=127.0.0.1:0")
+	err := h.Start()
+	assert.NoError(t, err)
+	defer h.Stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/hello", server.Addr()))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	err = h.Stop()
+	assert.NoError(t, err)
+}
+
+// testHangingRoutesModule routes /hang to a handler that blocks until its connection is closed.
+type testHangingRoutesModule struct {
+	handling chan struct{}
+}
+
+func (t *testHangingRoutesModule) Start(router app.Router) {
+	router.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+		close(t.handling)
+		<-r.Context().Done()
+	})
+}
+
+func TestModuleShutdownTimeout(t *testing.T) {
+	server := &Module{}
+	routes := &testHangingRoutesModule{handling: make(chan struct{})}
+	h := apptest.New(server, routes).Args("--http-bind=127.0.0.1:0", "--http-shutdown-timeout=10ms")
+	assert.NoError(t, h.Start())
+	go http.Get(fmt.Sprintf("http://%s/hang", server.Addr()))
+	<-routes.handling
+	err := h.Stop()
+	assert.EqualError(t, err, context.DeadlineExceeded.Error())
+}
+
+type testFailingModule struct{}
+
+func (t *testFailingModule) Start() error { return fmt.Errorf("failed") }
+
+func TestModuleFailedStart(t *testing.T) {
+	server := &Module{}
+	h := apptest.New(server, &testFailingModule{}).Args("--http-bind=127.0.0.1:0")
+	err := h.Start()
+	assert.EqualError(t, err, "*httpserver.testFailingModule.Start(): failed")
+
+	// The listener was closed, so the address can be bound again.
+	listener, err := net.Listen("tcp", server.Addr().String())
+	assert.NoError(t, err)
+	listener.Close()
+}
@@ -0,0 +1,276 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/inject"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Resolver is available for injection, resolving values from the injector at runtime.
+//
+// It is intended for the rare cases where the types a module needs can't be declared by its methods, such as
+// plugins. Prefer declaring dependencies as parameters to Start(...) or Provide*() methods: they are checked by
+// Validate() before anything is started, and they determine the order in which modules are started and stopped,
+// neither of which is possible for types resolved with a Resolver.
+//
+// A Resolver must not be used from a Provide*() method, as the injector is locked while providers are called.
+type Resolver interface {
+	// Get resolves a value of type t.
+	Get(t reflect.Type) (interface{}, error)
+	// Call f with its arguments obtained from the injector, returning its non-error return values.
+	Call(f interface{}) ([]interface{}, error)
+}
+
+// resolver implements Resolver.
+type resolver struct {
+	injector *syncInjector
+}
+
+func (r resolver) Get(t reflect.Type) (interface{}, error) { return r.injector.get(t) }
+
+func (r resolver) Call(f interface{}) ([]interface{}, error) { return r.injector.Call(f) }
+
+// syncInjector serialises access to the injector, allowing lifecycle methods to be called concurrently.
+//
+// It also records the types bound through it, allowing unsatisfied dependencies to be detected without resolving
+// them.
+type syncInjector struct {
+	// Shared with child injectors, as resolving from a child may resolve from its parent.
+	lock *sync.Mutex
+	*inject.SafeInjector
+	// If set, types are resolved from this child injector, containing overrides, rather than from SafeInjector.
+	overrides *inject.SafeInjector
+	bound     map[reflect.Type]bool
+	parent    *syncInjector
+	// Child injectors of Scoped modules.
+	scopes map[interface{}]*syncInjector
+	// If set, Install() calls this to install modules into the Application instead of only the injector.
+	install func(modules ...interface{})
+	// Modules whose Configure() method has succeeded, in order.
+	configured []interface{}
+	// Provide*() methods installed through this injector, by the type they provide.
+	providers map[reflect.Type]provider
+	// Values shared between modules. Only set on the root injector.
+	values *Values
+}
+
+func newSyncInjector(injector *inject.SafeInjector) *syncInjector {
+	return &syncInjector{
+		lock:         &sync.Mutex{},
+		SafeInjector: injector,
+		bound:        map[reflect.Type]bool{},
+		scopes:       map[interface{}]*syncInjector{},
+		providers:    map[reflect.Type]provider{},
+	}
+}
+
+// seedInjector creates the injector, seeded with the bindings and providers added with Bind() and Provide().
+//
+// These are bound to a parent of the returned injector, so that modules can bind the same types.
+func (a *Application) seedInjector() (*syncInjector, error) {
+	if len(a.bindings) == 0 && len(a.providers) == 0 {
+		return newSyncInjector(inject.SafeNew()), nil
+	}
+	seed := inject.SafeNew()
+	if err := seed.Bind(a.bindings...); err != nil {
+		return nil, fmt.Errorf("bind: %s", err)
+	}
+	for _, provider := range a.providers {
+		if t := reflect.TypeOf(provider); t == nil || t.Kind() != reflect.Func || t.NumOut() == 0 {
+			return nil, fmt.Errorf("provide: %T is not a provider function", provider)
+		}
+		if err := seed.Provide(provider); err != nil {
+			return nil, fmt.Errorf("provide: %s", err)
+		}
+	}
+	injector := newSyncInjector(seed.Child())
+	for _, value := range a.bindings {
+		injector.bound[reflect.TypeOf(value)] = true
+	}
+	for _, provider := range a.providers {
+		injector.bound[reflect.TypeOf(provider).Out(0)] = true
+	}
+	return injector, nil
+}
+
+// override installs modules whose providers replace those of any other module.
+func (s *syncInjector) override(modules ...interface{}) error {
+	s.overrides = s.SafeInjector.Child()
+	for _, module := range modules {
+		for _, t := range moduleTypes(module) {
+			s.bound[t] = true
+		}
+	}
+	return s.overrides.Install(modules...)
+}
+
+// resolver returns the injector to resolve types from.
+func (s *syncInjector) resolver() *inject.SafeInjector {
+	if s.overrides != nil {
+		return s.overrides
+	}
+	return s.SafeInjector
+}
+
+// child creates a child injector, with access to the bindings of its parent.
+func (s *syncInjector) child() *syncInjector {
+	return &syncInjector{
+		lock:         s.lock,
+		SafeInjector: s.SafeInjector.Child(),
+		bound:        map[reflect.Type]bool{},
+		parent:       s,
+		scopes:       map[interface{}]*syncInjector{},
+		providers:    map[reflect.Type]provider{},
+	}
+}
+
+// scope returns the injector for a module, which is its own child injector if it is Scoped.
+func (s *syncInjector) scope(module interface{}) *syncInjector {
+	if _, ok := module.(Scoped); !ok {
+		return s
+	}
+	if scope, ok := s.scopes[module]; ok {
+		return scope
+	}
+	return s
+}
+
+// Bind values to the injector.
+func (s *syncInjector) Bind(things ...interface{}) error {
+	for _, thing := range things {
+		s.bound[reflect.TypeOf(thing)] = true
+	}
+	return s.SafeInjector.Bind(things...)
+}
+
+// BindTo binds an implementation to an interface type.
+func (s *syncInjector) BindTo(as interface{}, impl interface{}) error {
+	s.bound[reflect.TypeOf(as).Elem()] = true
+	return s.SafeInjector.BindTo(as, impl)
+}
+
+// Provide a provider function to the injector.
+func (s *syncInjector) Provide(provider interface{}) error {
+	if t := reflect.TypeOf(provider); t.Kind() == reflect.Func && t.NumOut() > 0 {
+		s.bound[t.Out(0)] = true
+	}
+	return s.SafeInjector.Provide(provider)
+}
+
+// Install modules.
+//
+// While modules are being configured, modules are installed into the Application, otherwise only their providers
+// are installed into the injector.
+func (s *syncInjector) Install(modules ...interface{}) error {
+	root := s
+	for root.parent != nil {
+		root = root.parent
+	}
+	if root.install != nil {
+		root.install(modules...)
+		return nil
+	}
+	return s.installProviders(modules...)
+}
+
+// installProviders installs modules' providers into the injector.
+//
+// It is an error for two modules to provide the same type, other than sequences and mappings, which are merged.
+func (s *syncInjector) installProviders(modules ...interface{}) error {
+	for _, p := range moduleProviders(modules) {
+		if strings.HasSuffix(p.method, "Sequence") || strings.HasSuffix(p.method, "Mapping") {
+			continue
+		}
+		if existing, ok := s.providers[p.provides]; ok && existing.module != p.module {
+			return fmt.Errorf("%s is provided by both %T.%s() and %T.%s(), use Replace() to override one, or make "+
+				"one Scoped to keep it private", p.provides, existing.module, existing.method, p.module, p.method)
+		}
+		s.providers[p.provides] = p
+	}
+	for _, module := range modules {
+		for _, t := range moduleTypes(module) {
+			s.bound[t] = true
+		}
+	}
+	return s.SafeInjector.Install(modules...)
+}
+
+// Call f with its arguments obtained from the injector.
+//
+// Arguments are resolved while holding the lock, but f itself is called without it.
+func (s *syncInjector) Call(f interface{}) ([]interface{}, error) {
+	fv := reflect.ValueOf(f)
+	ft := fv.Type()
+	in := make([]reflect.Type, ft.NumIn())
+	for i := range in {
+		in[i] = ft.In(i)
+	}
+	var args []reflect.Value
+	capture := reflect.MakeFunc(reflect.FuncOf(in, nil, ft.IsVariadic()), func(values []reflect.Value) []reflect.Value {
+		args = values
+		return nil
+	})
+	s.lock.Lock()
+	_, err := s.resolver().Call(capture.Interface())
+	s.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var out []reflect.Value
+	if ft.IsVariadic() {
+		out = fv.CallSlice(args)
+	} else {
+		out = fv.Call(args)
+	}
+	if len(out) > 0 && ft.Out(len(out)-1) == errorType {
+		if err := out[len(out)-1]; !err.IsNil() {
+			return nil, err.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	results := make([]interface{}, 0, len(out))
+	for _, value := range out {
+		results = append(results, value.Interface())
+	}
+	return results, nil
+}
+
+// get resolves a value of type t from the injector.
+func (s *syncInjector) get(t reflect.Type) (interface{}, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.resolver().Get(t)
+}
+
+// provideAs provides value to the injector as type t.
+func (s *syncInjector) provideAs(t reflect.Type, value interface{}) error {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		v = reflect.Zero(t)
+	}
+	provider := reflect.MakeFunc(reflect.FuncOf(nil, []reflect.Type{t}, false), func([]reflect.Value) []reflect.Value {
+		return []reflect.Value{v}
+	})
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.SafeInjector.Provide(provider.Interface())
+}
+
+// export provides type t to the injector, resolving it from the child injector scope.
+func (s *syncInjector) export(scope *syncInjector, t reflect.Type) error {
+	provider := reflect.MakeFunc(reflect.FuncOf(nil, []reflect.Type{t, errorType}, false),
+		func([]reflect.Value) []reflect.Value {
+			// The lock is already held by the resolving Call().
+			value, err := scope.resolver().Get(t)
+			if err != nil {
+				return []reflect.Value{reflect.Zero(t), reflect.ValueOf(&err).Elem()}
+			}
+			return []reflect.Value{reflect.ValueOf(value), reflect.Zero(errorType)}
+		})
+	return s.Provide(provider.Interface())
+}
@@ -0,0 +1,774 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lifecycle is available for injection, giving modules control over the application lifecycle without exposing
+// the full Application.
+type Lifecycle interface {
+	// Command returns the selected command. It is empty until the command-line has been parsed.
+	Command() SelectedCommand
+	// Phase returns the current phase of the lifecycle, eg. so that a module can reject new work once the
+	// application is stopping. It is PhaseStopping as soon as shutdown begins, before any module is stopped.
+	Phase() Phase
+	// Shutdown begins a graceful shutdown by cancelling the root context. Calling it more than once has no further
+	// effect.
+	Shutdown()
+	// Go runs runner concurrently with the application, eg. from a module's Start(...) method.
+	//
+	// The runner is passed the root context. If it returns an error, other than context.Canceled, the root context
+	// is cancelled and the error is returned from Run. Once the application module's Start(...) and any provided
+	// Runners have returned, Run waits for all runners started with Go() to return before stopping modules.
+	Go(runner Runner)
+	// Fatalf begins a graceful shutdown, as for Shutdown(), after which Run returns an error with the given message.
+	//
+	// Unlike kingpin's Fatalf, it returns, so that modules are stopped and shutdown hooks are run before the
+	// application exits. The caller should return promptly.
+	Fatalf(format string, args ...interface{})
+	// FatalIfError calls Fatalf if err is non-nil, prefixing err with the formatted message, if any.
+	FatalIfError(err error, format string, args ...interface{})
+	// OnShutdown registers a function to be called during shutdown.
+	//
+	// Shutdown functions and the Stop(...) methods of started modules are called in the reverse of the order in
+	// which they were registered or started. A function registered from a module's Start(...) method is therefore
+	// called before the Stop(...) methods of the modules it depends on.
+	OnShutdown(f func() error)
+}
+
+// Shutdown is available for injection, for modules that only need to trigger a shutdown, eg. a watcher whose
+// configuration file was deleted.
+//
+// Calling it begins a graceful shutdown, as a shutdown signal would: the root context is cancelled, modules are
+// stopped in reverse order, and Run returns. Calling it more than once has no further effect.
+type Shutdown func()
+
+// Phase is a phase of the application lifecycle, returned by Lifecycle.Phase().
+type Phase int
+
+const (
+	// PhaseConfiguring is while modules are configured and the command-line is parsed.
+	PhaseConfiguring Phase = iota
+	// PhaseStarting is while modules are started.
+	PhaseStarting
+	// PhaseRunning is once every module has started, until shutdown begins.
+	PhaseRunning
+	// PhaseStopping is from when shutdown begins while modules are drained and stopped.
+	PhaseStopping
+	// PhaseStopped is once every module has stopped.
+	PhaseStopped
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseConfiguring:
+		return "configuring"
+	case PhaseStarting:
+		return "starting"
+	case PhaseRunning:
+		return "running"
+	case PhaseStopping:
+		return "stopping"
+	case PhaseStopped:
+		return "stopped"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// A shutdownHook is a function registered with Lifecycle.OnShutdown().
+type shutdownHook func() error
+
+// A closer is a value implementing io.Closer, returned from a module's Start(...) method or resolved as one of its
+// Eager types. Other values built by Provide*() methods are not closed (see Eager).
+type closer struct {
+	io.Closer
+}
+
+type lifecycle struct {
+	lock    sync.Mutex
+	command SelectedCommand
+	phase   Phase
+	ctx     context.Context
+	cancel  func()
+	// Started modules and shutdown hooks, in the order they were started or registered.
+	stack []interface{}
+	// Runners started with Go(), and the first error returned by one.
+	runners  sync.WaitGroup
+	groupErr error
+	// Runners started with Go() held until every module has started, while holding (see BarrierBeforeServe()).
+	holding bool
+	held    []Runner
+	// The first error passed to Fatalf() or FatalIfError().
+	fatal error
+	// Outstanding work to complete before stopping.
+	tasks tasks
+	// Holds taken with ShutdownGuard.
+	holds tasks
+}
+
+func (l *lifecycle) Command() SelectedCommand {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.command
+}
+
+func (l *lifecycle) setCommand(command SelectedCommand) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.command = command
+}
+
+func (l *lifecycle) Phase() Phase {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// Shutdown begins when the root context is cancelled, before the application is stopped.
+	if (l.phase == PhaseStarting || l.phase == PhaseRunning) && l.ctx.Err() != nil {
+		return PhaseStopping
+	}
+	return l.phase
+}
+
+func (l *lifecycle) setPhase(phase Phase) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.phase = phase
+}
+
+func (l *lifecycle) Shutdown() {
+	l.cancel()
+}
+
+func (l *lifecycle) Go(runner Runner) {
+	l.lock.Lock()
+	if l.holding {
+		l.held = append(l.held, runner)
+		l.lock.Unlock()
+		return
+	}
+	l.lock.Unlock()
+	l.runners.Add(1)
+	go func() {
+		defer l.runners.Done()
+		err := runner(l.ctx)
+		if err == nil || err == context.Canceled {
+			return
+		}
+		l.lock.Lock()
+		if l.groupErr == nil {
+			l.groupErr = err
+		}
+		l.lock.Unlock()
+		l.cancel()
+	}()
+}
+
+// release runners held until every module has started, and stop holding them.
+func (l *lifecycle) release() {
+	l.lock.Lock()
+	held := l.held
+	l.held, l.holding = nil, false
+	l.lock.Unlock()
+	for _, runner := range held {
+		l.Go(runner)
+	}
+}
+
+// wait for runners started with Go() to return, returning the first error.
+func (l *lifecycle) wait() error {
+	l.runners.Wait()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.groupErr
+}
+
+func (l *lifecycle) Fatalf(format string, args ...interface{}) {
+	l.fail(fmt.Errorf(format, args...))
+}
+
+func (l *lifecycle) FatalIfError(err error, format string, args ...interface{}) {
+	if err == nil {
+		return
+	}
+	l.fail(prefixError(err, format, args...))
+}
+
+// fail records the first fatal error and begins a graceful shutdown.
+func (l *lifecycle) fail(err error) {
+	l.lock.Lock()
+	if l.fatal == nil {
+		l.fatal = err
+	}
+	l.lock.Unlock()
+	l.cancel()
+}
+
+// fatalError returns the first error passed to Fatalf() or FatalIfError(), if any.
+func (l *lifecycle) fatalError() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.fatal
+}
+
+func (l *lifecycle) OnShutdown(f func() error) {
+	l.push(shutdownHook(f))
+}
+
+// push a started module or shutdown hook.
+func (l *lifecycle) push(entry interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.stack = append(l.stack, entry)
+}
+
+// pop the most recently started module or registered shutdown hook.
+func (l *lifecycle) pop() (interface{}, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.stack) == 0 {
+		return nil, false
+	}
+	entry := l.stack[len(l.stack)-1]
+	l.stack = l.stack[:len(l.stack)-1]
+	return entry, true
+}
+
+// remove a started module.
+func (l *lifecycle) remove(module interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, entry := range l.stack {
+		if entry == module {
+			l.stack = append(l.stack[:i:i], l.stack[i+1:]...)
+			return
+		}
+	}
+}
+
+// pending returns the started modules and shutdown hooks that have not been stopped, in the order they will be.
+func (l *lifecycle) pending() []interface{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	out := []interface{}{}
+	for i := len(l.stack) - 1; i >= 0; i-- {
+		out = append(out, l.stack[i])
+	}
+	return out
+}
+
+// started returns the started modules, in the order they started.
+func (l *lifecycle) started() []interface{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	out := []interface{}{}
+	for _, entry := range l.stack {
+		switch entry.(type) {
+		case shutdownHook, closer:
+		default:
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// configureModule installs the module's providers into the injector and calls its Configure() method, if any.
+//
+// A Scoped module is installed and configured into its own child injector, and its exported types are then
+// provided to the injector.
+func (a *Application) configureModule(injector *syncInjector, module interface{}) error {
+	start := time.Now()
+	scope := injector
+	if _, ok := module.(Scoped); ok {
+		scope = injector.child()
+		injector.scopes[module] = scope
+	}
+	if err := scope.installProviders(module); err != nil {
+		err = fmt.Errorf("%T: install: %s", module, err)
+		a.emit(EventErrored, module, start, err)
+		return err
+	}
+	a.emit(EventInstalled, module, start, nil)
+	if configurable, ok := module.(Configurable); ok {
+		start = time.Now()
+		err := a.guard(module, "Configure", func() error {
+			if err := configurable.Configure(scope); err != nil {
+				return ConfigureError{Module: module, Err: err}
+			}
+			return nil
+		})
+		if err != nil {
+			a.emit(EventErrored, module, start, err)
+			return err
+		}
+		a.emit(EventConfigured, module, start, nil)
+		injector.configured = append(injector.configured, module)
+	}
+	if scoped, ok := module.(Scoped); ok {
+		for _, t := range scoped.Exports() {
+			if err := injector.export(scope, t); err != nil {
+				err = fmt.Errorf("%T: export %s: %s", module, t, err)
+				a.emit(EventErrored, module, start, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deconfigure calls Deconfigure() on configured modules that were not started, in reverse, collecting any errors.
+func (a *Application) deconfigure(injector *syncInjector, started []interface{}) Errors {
+	isStarted := map[interface{}]bool{}
+	for _, module := range started {
+		isStarted[module] = true
+	}
+	errs := Errors{}
+	for i := len(injector.configured) - 1; i >= 0; i-- {
+		module := injector.configured[i]
+		deconfigurer, ok := module.(Deconfigurer)
+		if !ok || isStarted[module] {
+			continue
+		}
+		err := a.guard(module, "Deconfigure", func() error {
+			if err := deconfigurer.Deconfigure(); err != nil {
+				return fmt.Errorf("%T.Deconfigure(): %s", module, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	injector.configured = nil
+	return errs
+}
+
+// configureModules resolves, checks and configures the installed modules followed by the application module, if
+// any, returning the installed modules in install order.
+//
+// Modules installed by a module's Configure() method are configured in turn. Duplicate modules are ignored (see
+// isDuplicateModule()). Optional modules are not configured, but their "--enable-<name>" flags are returned in
+// enabled, indexed by module, and nil for other modules.
+//
+// If moduleFlags is non-nil the modules' flags are added to kingpin and recorded in moduleFlags.
+func (a *Application) configureModules(
+	injector *syncInjector, main interface{}, moduleFlags map[string][]string,
+) (modules []interface{}, enabled []*bool, err error) {
+	type queued struct {
+		module interface{}
+		nested bool
+	}
+	queue := []queued{}
+	for _, module := range a.modules {
+		queue = append(queue, queued{module, false})
+	}
+	injector.install = func(installed ...interface{}) {
+		for _, module := range installed {
+			queue = append(queue, queued{module, true})
+		}
+	}
+	defer func() { injector.install = nil }()
+	// Configure modules until none remain, including the application module once.
+	for len(queue) > 0 || main != nil {
+		if len(queue) == 0 {
+			if err := a.checkModule(main); err != nil {
+				return nil, nil, err
+			}
+			if err := a.configureModule(injector, main); err != nil {
+				return nil, nil, err
+			}
+			if moduleFlags != nil {
+				if err := a.structModule(moduleFlags, main, false); err != nil {
+					return nil, nil, err
+				}
+			}
+			main = nil
+			continue
+		}
+		next := queue[0]
+		queue = queue[1:]
+		module, err := resolveModule(injector, next.module)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isDuplicateModule(modules, module, next.nested) {
+			continue
+		}
+		if err := a.checkModule(module); err != nil {
+			return nil, nil, err
+		}
+		modules = append(modules, module)
+		if optional, ok := module.(Optional); ok {
+			var flag *bool
+			if moduleFlags != nil {
+				name := optional.Optional()
+				flag = a.moduleFlag(a.Application, "enable-"+name, fmt.Sprintf("Enable %s.", name)).Bool()
+			}
+			enabled = append(enabled, flag)
+		} else {
+			enabled = append(enabled, nil)
+			if err := a.configureModule(injector, module); err != nil {
+				return nil, nil, err
+			}
+		}
+		if moduleFlags != nil {
+			if err := a.structModule(moduleFlags, module, true); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return modules, enabled, nil
+}
+
+// isDuplicateModule returns true if module should not be installed because it duplicates one of modules.
+//
+// A module is a duplicate if it is already installed, or if a module of the same concrete type is installed and
+// either the module is a Singleton, or it was installed from another module's Configure() method (nested).
+func isDuplicateModule(modules []interface{}, module interface{}, nested bool) bool {
+	_, singleton := module.(Singleton)
+	t := reflect.TypeOf(module)
+	for _, m := range modules {
+		if m == module || ((nested || singleton) && reflect.TypeOf(m) == t) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartTimeout bounds how long each installed module's Start(...) method may take.
+//
+// If a module does not start within the timeout, the modules that did start are stopped and Run returns an
+// error identifying the module. The application module's Start(...) is not bounded.
+//
+// Note that the timed out Start(...) method continues to run in the background, so it should respect the
+// injected context.Context, which is cancelled when the application shuts down.
+func (a *Application) StartTimeout(timeout time.Duration) *Application {
+	a.startTimeout = timeout
+	return a
+}
+
+// Concurrency sets the maximum number of module Start(...) methods that may run concurrently.
+//
+// A module is only started once all of the modules it depends on (see Order()) have started. The default of 1
+// starts modules serially, in dependency order.
+func (a *Application) Concurrency(n int) *Application {
+	a.concurrency = n
+	return a
+}
+
+// startModules starts modules, pushing those that start successfully onto the lifecycle in the order they started.
+//
+// If a module fails to start, no further modules are started. Only modules on the lifecycle are stopped, so the
+// Stop(...) method of a module whose Start(...) failed or panicked is never called against its partially started
+// state.
+func (a *Application) startModules(
+	injector *syncInjector, lifecycle *lifecycle, modules []interface{}, concurrent bool,
+) error {
+	if !concurrent || a.concurrency <= 1 {
+		for _, module := range modules {
+			if err := a.startModule(injector, lifecycle, module); err != nil {
+				return err
+			}
+			lifecycle.push(module)
+		}
+		return nil
+	}
+	dependencies := moduleDependencies(modules)
+	var (
+		lock      sync.Mutex
+		firstErr  error
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, a.concurrency)
+		done      = make([]chan struct{}, len(modules))
+	)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return firstErr != nil
+	}
+	for i, module := range modules {
+		wg.Add(1)
+		go func(i int, module interface{}) {
+			defer wg.Done()
+			defer close(done[i])
+			for j := range dependencies[i] {
+				<-done[j]
+			}
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			if failed() {
+				return
+			}
+			err := a.startModule(injector, lifecycle, module)
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			lifecycle.push(module)
+		}(i, module)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// startModule resolves the module's Eager types, if any, then calls its Start(...) method or EntryPoint(), if any.
+//
+// Any of the resolved or returned values that implement io.Closer are pushed onto the lifecycle, to be closed
+// after the module is stopped. Failed attempts are retried according to the module's RetryPolicy, if any, in which
+// case values resolved or returned by more than one attempt are only closed once.
+func (a *Application) startModule(injector *syncInjector, lifecycle *lifecycle, module interface{}) error {
+	method := startMethod(module)
+	eager, isEager := module.(Eager)
+	if !method.IsValid() && !isEager {
+		return nil
+	}
+	start := time.Now()
+	closers := []io.Closer{}
+	err := a.retryStart(lifecycle.ctx, module, func() error {
+		var (
+			values []interface{}
+			err    error
+		)
+		if isEager {
+			values, err = resolveEager(injector.scope(module), eager.Eager())
+		}
+		if err == nil && method.IsValid() {
+			var results []interface{}
+			results, err = a.callStartWithTimeout(injector, module, method)
+			values = append(values, results...)
+		}
+		for _, value := range values {
+			if c, ok := value.(io.Closer); ok && !hasCloser(closers, c) {
+				closers = append(closers, c)
+			}
+		}
+		return err
+	})
+	for _, c := range closers {
+		lifecycle.push(closer{c})
+	}
+	if err != nil {
+		err = StartError{Module: module, Err: err}
+		a.emit(EventErrored, module, start, err)
+		return err
+	}
+	a.emit(EventStarted, module, start, nil)
+	return nil
+}
+
+// hasCloser returns true if c is one of closers.
+func hasCloser(closers []io.Closer, c io.Closer) bool {
+	if !reflect.TypeOf(c).Comparable() {
+		return false
+	}
+	for _, existing := range closers {
+		if existing == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Application) callStartWithTimeout(
+	injector *syncInjector, module interface{}, method reflect.Value,
+) ([]interface{}, error) {
+	type result struct {
+		values []interface{}
+		err    error
+	}
+	call := func() (out result) {
+		out.err = a.guard(module, "Start", func() (err error) {
+			out.values, err = callStart(injector.scope(module), injector, method)
+			return err
+		})
+		return out
+	}
+	if a.startTimeout == 0 {
+		r := call()
+		return r.values, r.err
+	}
+	results := make(chan result, 1)
+	go func() {
+		results <- call()
+	}()
+	timer := time.NewTimer(a.startTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.values, r.err
+	case <-timer.C:
+		return nil, startTimeoutError{module: module, timeout: a.startTimeout}
+	}
+}
+
+// startTimeoutError is returned if a module's Start(...) method does not complete within the StartTimeout(). As the
+// abandoned call may still be running, the module is not retried.
+type startTimeoutError struct {
+	module  interface{}
+	timeout time.Duration
+}
+
+func (e startTimeoutError) Error() string {
+	return fmt.Sprintf("did not complete within %s", e.timeout)
+}
+
+// resolveEager resolves each of a module's Eager types, returning their values.
+func resolveEager(injector *syncInjector, types []reflect.Type) ([]interface{}, error) {
+	injector.lock.Lock()
+	defer injector.lock.Unlock()
+	values := []interface{}{}
+	for _, t := range types {
+		value, err := injector.resolver().Get(t)
+		if err != nil {
+			return values, fmt.Errorf("eager %s: %s", t, err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// callStart calls a Start(...) method from the module's scope, providing any non-error return values to the
+// injector, and returning them.
+func callStart(scope, injector *syncInjector, method reflect.Value) ([]interface{}, error) {
+	results, err := scope.Call(method.Interface())
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if err := injector.provideAs(method.Type().Out(i), result); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// ShutdownTimeout bounds how long the Stop(...) methods of all modules, and all shutdown hooks, may take in total.
+//
+// If shutdown does not complete within the timeout, the modules still to be stopped are logged and Run returns
+// an error without waiting for them.
+func (a *Application) ShutdownTimeout(timeout time.Duration) *Application {
+	a.stopTimeout = timeout
+	return a
+}
+
+// shutdown calls the Stop(...) method of each started module, and each shutdown hook, in reverse order.
+func (a *Application) shutdown(injector *syncInjector, lifecycle *lifecycle) Errors {
+	var (
+		lock     sync.Mutex
+		errs     = Errors{}
+		stopping interface{}
+		timedOut bool
+	)
+	next := func() (interface{}, bool) {
+		lock.Lock()
+		defer lock.Unlock()
+		if timedOut {
+			return nil, false
+		}
+		entry, ok := lifecycle.pop()
+		stopping = entry
+		return entry, ok
+	}
+	stopAll := func() {
+		for entry, ok := next(); ok; entry, ok = next() {
+			err := a.stop(injector, entry)
+			lock.Lock()
+			if err != nil {
+				errs = append(errs, err)
+			}
+			lock.Unlock()
+		}
+	}
+	if a.stopTimeout == 0 {
+		stopAll()
+		return errs
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stopAll()
+	}()
+	timer := time.NewTimer(a.stopTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return errs
+	case <-timer.C:
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	timedOut = true
+	pending := []string{describeEntry(stopping)}
+	for _, entry := range lifecycle.pending() {
+		pending = append(pending, describeEntry(entry))
+	}
+	a.log().Errorf("shutdown timed out, still stopping: %s", strings.Join(pending, ", "))
+	return append(errs[:len(errs):len(errs)],
+		fmt.Errorf("shutdown did not complete within %s, still stopping %s", a.stopTimeout,
+			strings.Join(pending, ", ")))
+}
+
+// describeEntry returns a description of a started module or shutdown hook.
+func describeEntry(entry interface{}) string {
+	switch entry := entry.(type) {
+	case shutdownHook:
+		return "shutdown hook"
+	case closer:
+		return fmt.Sprintf("%T.Close()", entry.Closer)
+	}
+	return fmt.Sprintf("%T", entry)
+}
+
+// stop calls a shutdown hook, or a module's Stop(...) method, if any.
+func (a *Application) stop(injector *syncInjector, entry interface{}) error {
+	if hook, ok := entry.(shutdownHook); ok {
+		return a.guard(hook, "OnShutdown", func() error {
+			if err := hook(); err != nil {
+				return fmt.Errorf("shutdown hook: %s", err)
+			}
+			return nil
+		})
+	}
+	if c, ok := entry.(closer); ok {
+		return a.guard(c.Closer, "Close", func() error {
+			if err := c.Close(); err != nil {
+				return fmt.Errorf("%T.Close(): %s", c.Closer, err)
+			}
+			return nil
+		})
+	}
+	// Modules implementing io.Closer without a Stop(...) method are closed instead.
+	name := "Stop"
+	method := reflect.ValueOf(entry).MethodByName(name)
+	if c, ok := entry.(io.Closer); ok && !method.IsValid() {
+		name = "Close"
+		method = reflect.ValueOf(c.Close)
+	}
+	if !method.IsValid() {
+		return nil
+	}
+	start := time.Now()
+	err := a.guard(entry, name, func() error {
+		if _, err := injector.scope(entry).Call(method.Interface()); err != nil {
+			return fmt.Errorf("%T.%s(): %s", entry, name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		a.emit(EventErrored, entry, start, err)
+		return err
+	}
+	a.emit(EventStopped, entry, start, nil)
+	return nil
+}
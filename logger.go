@@ -0,0 +1,89 @@
+package app
+
+import (
+	"log"
+	"os"
+	"reflect"
+)
+
+// Logger is available for injection, giving modules a common logger.
+//
+// By default it logs to stderr, or the error writer set with Application.Writers(), discarding debug messages. Set
+// a different Logger with Application.Logger(), or install a module that provides one.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+var loggerType = reflect.TypeOf((*Logger)(nil)).Elem()
+
+// stderrLogger is the default Logger.
+type stderrLogger struct {
+	*log.Logger
+}
+
+var defaultLogger Logger = stderrLogger{log.New(os.Stderr, "", log.LstdFlags)}
+
+func (s stderrLogger) Debugf(format string, args ...interface{}) {}
+
+func (s stderrLogger) Infof(format string, args ...interface{}) {
+	s.Printf(format, args...)
+}
+
+func (s stderrLogger) Warnf(format string, args ...interface{}) {
+	s.Printf("warning: "+format, args...)
+}
+
+func (s stderrLogger) Errorf(format string, args ...interface{}) {
+	s.Printf("error: "+format, args...)
+}
+
+// Logger sets the Logger used by the Application, and bound into the injector unless a module provides one.
+func (a *Application) Logger(logger Logger) *Application {
+	a.logger = logger
+	return a
+}
+
+// log returns the Logger used by the application, which is the injected Logger while the application is running.
+func (a *Application) log() Logger {
+	a.runLock.Lock()
+	defer a.runLock.Unlock()
+	if a.runLogger != nil {
+		return a.runLogger
+	}
+	if a.logger != nil {
+		return a.logger
+	}
+	if a.errorWriter != nil {
+		return stderrLogger{log.New(a.errorWriter, "", log.LstdFlags)}
+	}
+	return defaultLogger
+}
+
+// setLogger sets the injected Logger while the application is running.
+func (a *Application) setLogger(logger Logger) {
+	a.runLock.Lock()
+	defer a.runLock.Unlock()
+	a.runLogger = logger
+}
+
+// bindLogger binds the application Logger into the injector, unless it is already bound or provided by a module,
+// then uses the injected Logger for the rest of the run.
+func (a *Application) bindLogger(injector *syncInjector, modules []interface{}) error {
+	provided := injector.bound[loggerType]
+	for _, module := range modules {
+		for _, t := range providedTypes(module) {
+			provided = provided || t == loggerType
+		}
+	}
+	if !provided {
+		logger := a.log()
+		if err := injector.Provide(func() Logger { return logger }); err != nil {
+			return err
+		}
+	}
+	_, err := injector.Call(func(logger Logger) { a.setLogger(logger) })
+	return err
+}
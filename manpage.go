@@ -0,0 +1,38 @@
+package app
+
+import (
+	"io"
+
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// addManPageFlag adds the hidden --help-man flag, unless kingpin already provides it.
+func (a *Application) addManPageFlag() {
+	if a.GetFlag("help-man") == nil {
+		a.Flag("help-man", "Generate a man page.").Hidden().Bool()
+	}
+}
+
+// ManPage writes a man page for the application's commands and flags to w, rendered with kingpin's roff template.
+//
+// As with GenerateCompletion(), flags added by modules are only included once the application is running. The
+// hidden --help-man flag instead writes the man page once all modules have been installed and configured, then
+// terminates the application, eg.
+//
+//		myapp --help-man > myapp.1
+func (a *Application) ManPage(w io.Writer) error {
+	return a.renderTemplate(w, kingpin.ManPageTemplate)
+}
+
+// hasFlag returns true if the flag --name is present in args.
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if arg == "--"+name {
+			return true
+		}
+	}
+	return false
+}
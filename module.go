@@ -0,0 +1,248 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Lifecycle method names, keyed by their lower case form.
+var lifecycleMethods = map[string]string{
+	"configure": "Configure",
+	"prestart":  "PreStart",
+	"start":     "Start",
+	"stop":      "Stop",
+}
+
+// An Optional module is disabled unless enabled from the command-line with "--enable-<name>".
+//
+// Disabled modules are not installed into the injector, so their providers are unavailable, and none of their
+// lifecycle methods are called. As whether the module is enabled is only known once the command-line has been
+// parsed, the Configure() method of an enabled optional module is called after parsing, rather than before.
+type Optional interface {
+	// Optional returns the name of the module's "--enable-<name>" flag.
+	Optional() string
+}
+
+// An Eager module has the given types resolved when it starts, before its Start(...) method, if any, is called.
+//
+// Providers are only called once, when their type is first required, so this can be used to construct expensive
+// values such as connection pools at startup, surfacing any errors before the application runs. As with types
+// injected into Start(...), modules providing Eager types are started first.
+//
+// Eager types implementing io.Closer are closed after the module stops. Values built by Provide*() methods are
+// otherwise never closed, so a module providing an io.Closer should list its type to have it closed.
+type Eager interface {
+	Eager() []reflect.Type
+}
+
+// An EntryPoint module is started by calling the function returned by EntryPoint(), instead of its Start(...)
+// method.
+//
+// This allows a type whose existing method has a different name, such as Run(...) or Serve(...), to be used as a
+// module without a wrapper. The function is injected exactly as Start(...) would be, and must follow the same
+// rules for its return values.
+type EntryPoint interface {
+	// EntryPoint returns the function to call to start the module.
+	EntryPoint() interface{}
+}
+
+// startMethod returns the function called to start a module: the function returned by its EntryPoint(), if it is
+// an EntryPoint, otherwise its Start(...) method. The returned value is invalid if there is neither.
+func startMethod(module interface{}) reflect.Value {
+	entry, ok := module.(EntryPoint)
+	if !ok {
+		return reflect.ValueOf(module).MethodByName("Start")
+	}
+	if fn := reflect.ValueOf(entry.EntryPoint()); fn.Kind() == reflect.Func && !fn.IsNil() {
+		return fn
+	}
+	return reflect.Value{}
+}
+
+// A Singleton module is only installed once, regardless of how many instances are passed to Install().
+//
+// Modules installed from another module's Configure() method are always treated as singletons.
+type Singleton interface {
+	Singleton()
+}
+
+// A Named module declares its own name.
+type Named interface {
+	// ModuleName returns the name of the module.
+	ModuleName() string
+}
+
+// ModuleName returns the name of a module, used to identify it in configuration files.
+//
+// If the module implements Named, its ModuleName() is used. Otherwise the name is derived from the module's type:
+// types named "Module" use their package name, eg. "mongo" for *mongo.Module, while other types use their lower
+// cased type name, eg. "server" for *main.Server.
+func ModuleName(module interface{}) string {
+	if named, ok := module.(Named); ok {
+		return named.ModuleName()
+	}
+	t := reflect.TypeOf(module)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "Module" && t.PkgPath() != "" {
+		return path.Base(t.PkgPath())
+	}
+	return strings.ToLower(t.Name())
+}
+
+// Module describes an installed module.
+type Module struct {
+	// Name of the module's type, eg. "*mongo.Module".
+	Name string
+	// Module instance.
+	Module interface{}
+	// Configurable is true if the module implements Configurable.
+	Configurable bool
+	// Starter is true if the module has a Start(...) method, or is an EntryPoint.
+	Starter bool
+	// Stopper is true if the module has a Stop(...) method.
+	Stopper bool
+}
+
+// Describe a module.
+func Describe(module interface{}) Module {
+	mv := reflect.ValueOf(module)
+	_, configurable := module.(Configurable)
+	return Module{
+		Name:         fmt.Sprintf("%T", module),
+		Module:       module,
+		Configurable: configurable,
+		Starter:      startMethod(module).IsValid(),
+		Stopper:      mv.MethodByName("Stop").IsValid(),
+	}
+}
+
+// hasLifecycle returns true if the framework calls any of a module's methods other than its providers.
+func hasLifecycle(module interface{}) bool {
+	description := Describe(module)
+	_, prestarter := module.(PreStarter)
+	_, eager := module.(Eager)
+	_, closer := module.(io.Closer)
+	return description.Configurable || description.Starter || description.Stopper || prestarter || eager || closer
+}
+
+// Modules returns a copy of the installed modules, in install order.
+//
+// Modules installed via factory functions are returned as the factory function.
+func (a *Application) Modules() []interface{} {
+	return append([]interface{}{}, a.modules...)
+}
+
+// checkModule checks a module's lifecycle methods for mistakes that would otherwise cause them to be silently
+// ignored.
+//
+// Start(...), or the function returned by EntryPoint(), must return nothing, an error, or values followed by an
+// error, and Stop(...) must return either nothing or an error. Methods whose names differ from a lifecycle
+// method only by case, and Configure or PreStart methods with the wrong signature, are logged as warnings.
+func (a *Application) checkModule(module interface{}) error {
+	mt := reflect.TypeOf(module)
+	for i := 0; i < mt.NumMethod(); i++ {
+		method := mt.Method(i)
+		expected, ok := lifecycleMethods[strings.ToLower(method.Name)]
+		if !ok {
+			continue
+		}
+		if method.Name != expected {
+			a.log().Warnf("%T.%s() will not be called, did you mean %s()?", module, method.Name, expected)
+			continue
+		}
+		switch method.Name {
+		case "Start":
+			if _, ok := module.(EntryPoint); ok {
+				a.log().Warnf("%T.Start() will not be called as it is an app.EntryPoint", module)
+				continue
+			}
+			out := method.Type.NumOut()
+			if out > 0 && method.Type.Out(out-1) != errorType {
+				return fmt.Errorf("%T.Start() must return nothing, an error, or values followed by an error, not %s",
+					module, reflect.ValueOf(module).Method(i).Type())
+			}
+		case "Stop":
+			out := method.Type.NumOut()
+			if out > 1 || (out == 1 && method.Type.Out(0) != errorType) {
+				return fmt.Errorf("%T.Stop() must return either nothing or an error, not %s", module,
+					reflect.ValueOf(module).Method(i).Type())
+			}
+		case "Configure":
+			if _, ok := module.(Configurable); !ok {
+				a.log().Warnf("%T.Configure() will not be called as it does not implement app.Configurable",
+					module)
+			}
+		case "PreStart":
+			if _, ok := module.(PreStarter); !ok {
+				a.log().Warnf("%T.PreStart() will not be called as it does not implement app.PreStarter",
+					module)
+			}
+		}
+	}
+	if entry, ok := module.(EntryPoint); ok {
+		start := startMethod(module)
+		if !start.IsValid() {
+			return fmt.Errorf("%T.EntryPoint() must return a function, not %T", module, entry.EntryPoint())
+		}
+		if out := start.Type().NumOut(); out > 0 && start.Type().Out(out-1) != errorType {
+			return fmt.Errorf("%T.EntryPoint() must return a function returning nothing, an error, or values "+
+				"followed by an error, not %s", module, start.Type())
+		}
+	}
+	return nil
+}
+
+// validateModule checks that module is a non-nil pointer to a struct, or a module factory function.
+func validateModule(module interface{}) error {
+	v := reflect.ValueOf(module)
+	switch {
+	case module == nil:
+		return fmt.Errorf("module must not be nil")
+	case v.Kind() == reflect.Func:
+		if v.IsNil() {
+			return fmt.Errorf("module factory %T must not be nil", module)
+		}
+		return nil
+	case v.Kind() != reflect.Ptr || v.Type().Elem().Kind() != reflect.Struct:
+		return fmt.Errorf("module must be a pointer to a struct or a module factory, not %T", module)
+	case v.IsNil():
+		return fmt.Errorf("module %T must not be nil", module)
+	}
+	return nil
+}
+
+// resolveModule returns module, or if it is a factory function, the module returned by calling it, which must be a
+// non-nil pointer to a struct.
+func resolveModule(injector *syncInjector, module interface{}) (interface{}, error) {
+	if module == nil {
+		return nil, fmt.Errorf("module must not be nil")
+	}
+	if reflect.TypeOf(module).Kind() != reflect.Func {
+		return module, nil
+	}
+	results, err := injector.Call(module)
+	if err != nil {
+		return nil, fmt.Errorf("module factory %T: %s", module, err)
+	}
+	if len(results) != 1 || results[0] == nil || reflect.TypeOf(results[0]).Kind() == reflect.Func {
+		return nil, fmt.Errorf("module factory %T must return a module, optionally followed by an error", module)
+	}
+	if err := validateModule(results[0]); err != nil {
+		return nil, fmt.Errorf("module factory %T: %s", module, err)
+	}
+	return results[0], nil
+}
+
+// installedModules returns the installed modules. Once the application has run, module factories are replaced by
+// the modules they constructed.
+func (a *Application) installedModules() []interface{} {
+	if a.installed != nil {
+		return a.installed
+	}
+	return a.modules
+}
@@ -0,0 +1,26 @@
+package app
+
+// A FlagNamespace module declares the namespace its flags are prefixed with when flags are namespaced.
+type FlagNamespace interface {
+	// FlagNamespace returns the namespace, eg. "http" for flags such as --http.timeout.
+	FlagNamespace() string
+}
+
+// NamespaceFlags prefixes the flags of each installed module with a namespace, eg. --http.timeout, so that
+// independently written modules can declare flags with the same name.
+//
+// The namespace is the module's FlagNamespace() if it implements FlagNamespace, otherwise its ModuleName(). The
+// flags of the application module and of command modules are not namespaced, and namespaced flags do not have
+// short names. Configuration file keys (see ConfigFile()) remain the flag names without their namespace.
+func (a *Application) NamespaceFlags(namespace bool) *Application {
+	a.namespaceFlags = namespace
+	return a
+}
+
+// flagNamespace returns the namespace for a module's flags.
+func flagNamespace(module interface{}) string {
+	if namespace, ok := module.(FlagNamespace); ok {
+		return namespace.FlagNamespace()
+	}
+	return ModuleName(module)
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// An Option configures an Application when passed to New().
+//
+// Each option is equivalent to calling the corresponding Application method.
+type Option func(a *Application)
+
+// WithHelp sets the application help. See Application.Help().
+func WithHelp(help string) Option {
+	return func(a *Application) { a.Help(help) }
+}
+
+// WithVersion sets the application version, and adds a --version flag. See Application.Version().
+func WithVersion(version string) Option {
+	return func(a *Application) { a.Version(version) }
+}
+
+// WithWriters sets the writers usage information and errors are written to. See Application.Writers().
+func WithWriters(out, err io.Writer) Option {
+	return func(a *Application) { a.Writers(out, err) }
+}
+
+// WithSignals enables graceful shutdown when any of the given signals are received. See Application.WithSignals().
+func WithSignals(signals ...os.Signal) Option {
+	return func(a *Application) { a.WithSignals(signals...) }
+}
+
+// WithDrainTimeout bounds how long the Drain() methods of all modules may take. See Application.DrainTimeout().
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(a *Application) { a.DrainTimeout(timeout) }
+}
+
+// WithStartTimeout bounds how long each installed module's Start(...) method may take. See
+// Application.StartTimeout().
+func WithStartTimeout(timeout time.Duration) Option {
+	return func(a *Application) { a.StartTimeout(timeout) }
+}
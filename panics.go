@@ -0,0 +1,28 @@
+package app
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// RecoverPanics controls whether panics in module lifecycle methods are recovered.
+//
+// By default a panic in a module's Configure(), PreStart(), Start(...) or Stop(...) method is converted into an
+// error, including the module and stack trace, and any modules that have started are stopped. Pass false to let
+// panics propagate instead.
+func (a *Application) RecoverPanics(recover bool) *Application {
+	a.noRecover = !recover
+	return a
+}
+
+// guard calls f, converting any panic into an error identifying the module's method.
+func (a *Application) guard(module interface{}, method string, f func() error) (err error) {
+	if !a.noRecover {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("%T.%s() panicked: %v\n%s", module, method, r, debug.Stack())
+			}
+		}()
+	}
+	return f()
+}
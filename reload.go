@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// A Reloader module is called when the application is reloaded, eg. on SIGHUP, to apply any changed configuration.
+type Reloader interface {
+	// Reload is called once the configuration file and command-line have been re-read into module fields.
+	//
+	// Modules that can't apply changes while running should not implement Reloader.
+	Reload(binder Binder) error
+}
+
+// reloadState is the state required to reload a running application.
+type reloadState struct {
+	lock        sync.Mutex
+	args        []string
+	moduleFlags map[string][]string
+	modules     []interface{}
+}
+
+// ReloadOn reloads the application, as with Reload(), when any of the given signals are received.
+//
+// If no signals are provided, SIGHUP is used. Errors are logged, and do not stop the application.
+func (a *Application) ReloadOn(signals ...os.Signal) *Application {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	a.reloadSignals = signals
+	return a
+}
+
+// Reload re-reads the configuration file and sources, if any, and the command-line into module fields, then calls
+// the Reload() method of each module implementing Reloader, in start order.
+//
+// Flag values are updated in place while modules may be running, so a module should only read its fields from
+// its Reload() method, or otherwise synchronise access to them. Reloads are serialised. It may only be called while
+// the application module's Start(...) method is running.
+func (a *Application) Reload() error {
+	a.lock.Lock()
+	state, injector := a.reloading, a.injector
+	a.lock.Unlock()
+	if state == nil || injector == nil {
+		return fmt.Errorf("can't reload as the application is not running")
+	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	if err := a.loadConfigFile(state.args, state.moduleFlags); err != nil {
+		return err
+	}
+	if err := a.loadConfigSources(); err != nil {
+		return err
+	}
+	if err := a.applyFlagValues(); err != nil {
+		return err
+	}
+	if _, err := a.Parse(state.args); err != nil {
+		return err
+	}
+	errs := Errors{}
+	for _, module := range state.modules {
+		if reloader, ok := module.(Reloader); ok {
+			err := a.guard(module, "Reload", func() error {
+				if err := reloader.Reload(injector.scope(module)); err != nil {
+					return fmt.Errorf("%T.Reload(): %s", module, err)
+				}
+				return nil
+			})
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs.Err()
+}
+
+// handleReloadSignals reloads the application on each reload signal received.
+//
+// The returned function must be called to stop handling signals.
+func (a *Application) handleReloadSignals() (stop func()) {
+	if len(a.reloadSignals) == 0 {
+		return func() {}
+	}
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, a.reloadSignals...)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if err := a.Reload(); err != nil {
+					a.log().Errorf("reload on %s failed: %s", sig, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func (a *Application) setReloading(state *reloadState) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.reloading = state
+}
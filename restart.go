@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+)
+
+// setRunning records the injector, lifecycle and application module while the application module is running.
+func (a *Application) setRunning(injector *syncInjector, lifecycle *lifecycle, main interface{}) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.injector = injector
+	a.lifecycle = lifecycle
+	a.main = main
+}
+
+// RestartModule stops, reconfigures, and starts a running module, eg. to apply new configuration.
+//
+// "name" is the name of the module's type, as returned by Describe(), eg. "*mongo.Module". It may only be called
+// while the application module's Start(...) method is running.
+//
+// Bindings made by the module's providers can not be replaced, so only modules that no other module depends on
+// (see Order()), such as those that only consume values or serve requests, may be restarted. Bindings made from
+// Configure() and values returned by Start(...) are made in a child injector, and are only visible to the
+// restarted module. If the module fails to restart, it is no longer considered started and will not be stopped
+// on shutdown. Restarts are serialised.
+func (a *Application) RestartModule(name string) error {
+	a.restartLock.Lock()
+	defer a.restartLock.Unlock()
+	// The module's methods may call eg. Call() or Reload(), so the lock isn't held while calling them.
+	a.lock.Lock()
+	injector, lifecycle := a.injector, a.lifecycle
+	a.lock.Unlock()
+	if lifecycle == nil {
+		return fmt.Errorf("can't restart %s as the application is not running", name)
+	}
+	started := lifecycle.started()
+	index := -1
+	for i, module := range started {
+		if Describe(module).Name == name {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("can't restart %s as it is not a started module", name)
+	}
+	module := started[index]
+	for i, dependencies := range moduleDependencies(started) {
+		if dependencies[index] {
+			return fmt.Errorf("can't restart %s as %T depends on it", name, started[i])
+		}
+	}
+	lifecycle.remove(module)
+	if err := a.stop(injector, module); err != nil {
+		return err
+	}
+	child := injector.scope(module).child()
+	if configurable, ok := module.(Configurable); ok {
+		err := a.guard(module, "Configure", func() error { return configurable.Configure(child) })
+		if err != nil {
+			return fmt.Errorf("%s.Configure(): %s", name, err)
+		}
+	}
+	if err := a.startModule(child, lifecycle, module); err != nil {
+		return err
+	}
+	lifecycle.push(module)
+	return nil
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// A RetryPolicy determines whether a module's failed Start(...) method is retried, and after how long.
+type RetryPolicy interface {
+	// Retry returns how long to wait before retrying after the given attempt, counting from 1, failed with err, or
+	// false to give up.
+	Retry(attempt int, err error) (time.Duration, bool)
+}
+
+// Backoff returns a RetryPolicy that retries until a module has made the given number of attempts to start, waiting
+// delay after the first failed attempt and doubling it after each subsequent attempt, up to max.
+func Backoff(attempts int, delay, max time.Duration) RetryPolicy {
+	return backoff{attempts: attempts, delay: delay, max: max}
+}
+
+// backoff implements Backoff().
+type backoff struct {
+	attempts int
+	delay    time.Duration
+	max      time.Duration
+}
+
+func (b backoff) Retry(attempt int, err error) (time.Duration, bool) {
+	if attempt >= b.attempts {
+		return 0, false
+	}
+	delay := b.delay
+	for i := 1; i < attempt && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+	return delay, true
+}
+
+// A startRetry is a RetryPolicy registered with StartRetries().
+type startRetry struct {
+	module interface{}
+	policy RetryPolicy
+}
+
+// StartRetries retries the Start(...) method of a module according to policy if it fails, eg. as the external
+// service it connects to is not yet available:
+//
+//		app.StartRetries(db, app.Backoff(5, time.Second, time.Minute))
+//
+// module is either an installed module or its reflect.Type, eg. for modules installed via factory functions. Each
+// attempt is bounded by StartTimeout(), if set, but an attempt that times out is not retried, as its Start(...)
+// method may still be running. If the application shuts down while waiting to retry, or the policy gives up, the
+// module fails to start with its last error.
+func (a *Application) StartRetries(module interface{}, policy RetryPolicy) *Application {
+	a.startRetries = append(a.startRetries, startRetry{module: module, policy: policy})
+	return a
+}
+
+// retryPolicy returns the RetryPolicy registered for module, or nil.
+func (a *Application) retryPolicy(module interface{}) RetryPolicy {
+	for _, retry := range a.startRetries {
+		if t, ok := retry.module.(reflect.Type); (ok && t == reflect.TypeOf(module)) || retry.module == module {
+			return retry.policy
+		}
+	}
+	return nil
+}
+
+// retryStart calls start until it succeeds, times out, or the module's RetryPolicy, if any, gives up or ctx is
+// cancelled.
+func (a *Application) retryStart(ctx context.Context, module interface{}, start func() error) error {
+	policy := a.retryPolicy(module)
+	for attempt := 1; ; attempt++ {
+		err := start()
+		if _, timedOut := err.(startTimeoutError); err == nil || policy == nil || timedOut {
+			return err
+		}
+		delay, ok := policy.Retry(attempt, err)
+		if !ok {
+			if attempt > 1 {
+				err = fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+		a.log().Warnf("%T.Start() failed, retrying in %s: %s", module, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package app
+
+import (
+	"net/http"
+)
+
+// Router is available for injection from an HTTP server module, such as httpserver.Module, for modules to register
+// HTTP handlers on a shared mux, eg. metrics, health checks or the application's own API.
+//
+// It is implemented by *http.ServeMux. Modules register their routes from their Start(...) method, and so are
+// started after the module providing the Router, which serves them once every module has started.
+//
+//		func (m *Module) Start(router app.Router) {
+//			router.Handle("/metrics", promhttp.Handler())
+//		}
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
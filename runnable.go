@@ -0,0 +1,206 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// A Runnable is an application that has been built by Build(), allowing the caller to control when it starts
+// and stops, eg. in integration tests.
+type Runnable struct {
+	app        *Application
+	ctx        context.Context
+	cancel     func()
+	shutdown   context.Context // Cancelled only when the application is shut down, unlike ctx.
+	lifecycle  *lifecycle
+	injector   *syncInjector
+	main       interface{}
+	start      reflect.Value
+	order      []interface{}
+	concurrent bool
+	reload     *reloadState
+	// Functions releasing resources held by the Runnable, called in reverse.
+	cleanups []func()
+
+	lock sync.Mutex
+	// Closed once the Runnable has failed to start, or the application module and Runners have returned.
+	running chan struct{}
+	// The error from starting or running the application, if any. Only valid once running is closed.
+	err error
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+// Build installs and configures modules, parses args, and validates the application, without starting it.
+//
+// The returned Runnable can then be started and stopped by the caller. It must be stopped, even if it is not
+// started, to release its resources. Run is equivalent to Build(), Start() and Wait().
+func (a *Application) Build(args []string, module interface{}) (*Runnable, error) {
+	return a.prepare(context.Background(), args, module, prepareRun)
+}
+
+// ConfigureOnly installs and configures modules, parses args, and validates the application, then returns without
+// starting it, eg. for tooling that lints an application's configuration.
+//
+// Unlike DryRun(), signals are not handled and PreStart() methods are not called: only module factories and the
+// Configure(), PostParse() and Validate() methods of modules are called, none of which should open listeners or
+// connections. Deconfigurer modules are deconfigured before it returns.
+func (a *Application) ConfigureOnly(args []string, module interface{}) error {
+	r, err := a.prepare(context.Background(), args, module, prepareConfigureOnly)
+	if err != nil {
+		return err
+	}
+	return r.Stop()
+}
+
+// CommandModel returns the model of the application's commands, flags and arguments once all modules have been
+// installed and configured, eg. to render custom help output.
+//
+// Unlike Model(), the model includes the flags added by modules, with their defaults from the configuration file,
+// sources, SetFlag() and environment variables applied. As with ConfigureOnly(), signals are not handled, PreStart()
+// methods are not called and Deconfigurer modules are deconfigured before it returns. module is the application
+// module, as passed to Run().
+func (a *Application) CommandModel(module interface{}) (*kingpin.ApplicationModel, error) {
+	r, err := a.prepare(context.Background(), nil, module, prepareModel)
+	if err != nil {
+		return nil, err
+	}
+	model := a.Model()
+	return model, r.Stop()
+}
+
+// Start the installed modules, in dependency order, then run the application module's Start(...) method and any
+// Runners in the background.
+//
+// If Start fails, Stop must still be called to stop any modules that did start.
+func (r *Runnable) Start() error {
+	r.lock.Lock()
+	if r.running != nil {
+		r.lock.Unlock()
+		return fmt.Errorf("application already started")
+	}
+	running := make(chan struct{})
+	r.running = running
+	r.lock.Unlock()
+	a := r.app
+	r.lifecycle.setPhase(PhaseStarting)
+	err := a.callPhase(r.order, "BeforeStart", false).Err()
+	if err == nil {
+		err = a.startModules(r.injector, r.lifecycle, r.order, r.concurrent)
+	}
+	if err == nil {
+		err = a.callPhase(r.order, "AfterStart", false).Err()
+	}
+	if err != nil {
+		r.err = err
+		close(running)
+		return err
+	}
+	r.lifecycle.setPhase(PhaseRunning)
+	r.lifecycle.release()
+	a.setReloading(r.reload)
+	r.cleanups = append(r.cleanups, func() { a.setReloading(nil) }, a.handleReloadSignals())
+	a.setRunning(r.injector, r.lifecycle, r.main)
+	go func() {
+		defer close(running)
+		// Cancel the root context once the application module and any Runners return, including if they fail.
+		defer r.cancel()
+		runMain := func(context.Context) error {
+			err := a.guard(r.main, "Start", func() error {
+				_, err := r.injector.Call(r.start.Interface())
+				return err
+			})
+			if err != nil && err != context.Canceled {
+				err = StartError{Module: r.main, Err: err}
+			}
+			return err
+		}
+		// A blocking application module is run alongside any Runners, and shutdown begins when it returns.
+		if _, ok := r.main.(Blocking); ok {
+			r.err = a.runRunners(r.ctx, r.cancel, r.injector, runMain)
+		} else if r.err = runMain(r.ctx); r.err == nil {
+			r.err = a.runRunners(r.ctx, r.cancel, r.injector)
+		}
+	}()
+	return nil
+}
+
+// Wait for the application module's Start(...) method, any Runners, any Tasks, and any runners started with
+// Lifecycle.Go() to return, then Stop the application, returning any errors.
+func (r *Runnable) Wait() error {
+	r.lock.Lock()
+	running := r.running
+	r.lock.Unlock()
+	if running != nil {
+		<-running
+		// Once running, the root context has already been cancelled, as the application module or a Runner returned.
+		// Cancel it if the application failed to start, stopping runners started with Lifecycle.Go(), otherwise wait
+		// for any Tasks, which only shutting down stops.
+		if r.err != nil {
+			r.cancel()
+		} else {
+			r.lifecycle.tasks.wait(r.shutdown)
+		}
+		_ = r.lifecycle.wait()
+	}
+	return r.Stop()
+}
+
+// Stop the application by cancelling the root context, waiting for the application module and any runners to
+// return, then stopping started modules in reverse dependency order. Deconfigurer modules that were not started are
+// then deconfigured.
+//
+// Errors from starting, running and stopping the application are returned. Only the first call has any effect,
+// with subsequent calls returning the same result.
+func (r *Runnable) Stop() error {
+	r.stopOnce.Do(func() { r.stopErr = r.stop() })
+	return r.stopErr
+}
+
+func (r *Runnable) stop() error {
+	a := r.app
+	defer r.cleanup()
+	defer r.lifecycle.setPhase(PhaseStopped)
+	r.lock.Lock()
+	running := r.running
+	r.lock.Unlock()
+	errs := Errors{}
+	r.lifecycle.setPhase(PhaseStopping)
+	r.lifecycle.Shutdown()
+	if running != nil {
+		<-running
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+	if err := r.lifecycle.wait(); err != nil && len(errs) == 0 {
+		errs = append(errs, err)
+	}
+	a.setRunning(nil, nil, nil)
+	a.waitForHolds(&r.lifecycle.holds)
+	// Drain, then call Stop(...) methods of started modules and shutdown hooks in reverse, collecting any errors,
+	// then deconfigure modules that never started.
+	stopping := reversed(r.lifecycle.started())
+	errs = append(errs, a.callPhase(stopping, "BeforeStop", true)...)
+	errs = append(errs, a.drain(stopping)...)
+	errs = append(errs, a.shutdown(r.injector, r.lifecycle)...)
+	errs = append(errs, a.callPhase(stopping, "AfterStop", true)...)
+	errs = append(errs, a.deconfigure(r.injector, stopping)...)
+	if err := r.lifecycle.fatalError(); err != nil {
+		errs = append(Errors{err}, errs...)
+	}
+	return errs.Err()
+}
+
+// cleanup releases the resources held by the Runnable.
+func (r *Runnable) cleanup() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+	r.cleanups = nil
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"reflect"
+)
+
+// A Runner is a long-running function, such as a server's accept loop.
+//
+// Modules provide Runners from a sequence provider method, eg.
+//
+//		func (m *Module) ProvideRunnerSequence(server *http.Server) []app.Runner {
+//			return []app.Runner{func(ctx context.Context) error { return server.ListenAndServe() }}
+//		}
+//
+// Once every module and the application module have started, all Runners are run concurrently until either one of
+// them returns or the root context is cancelled. The root context is then cancelled, and Run waits for the
+// remaining Runners to return before stopping modules. The first error returned by a Runner, other than
+// context.Canceled, is returned from Run.
+//
+// This separates wiring modules together, in Start(...), from serving, in Runners. Runners can also be started
+// directly from Start(...) with Lifecycle.Go().
+//
+// If the application module is Blocking, its Start(...) method is run concurrently with the Runners, as if it were
+// one of them. Otherwise the Runners are run once it has returned.
+type Runner func(ctx context.Context) error
+
+// A Blocking application module's Start(...) method blocks, eg. serving until the root context is cancelled by a
+// signal, and shutdown begins when it returns. Any provided Runners are run alongside it.
+//
+// The Start(...) method of an application module that isn't Blocking may still accept a context.Context, but
+// should return promptly, leaving the application to run until its Runners return.
+type Blocking interface {
+	// Blocking marks the application module as blocking.
+	Blocking()
+}
+
+// BarrierBeforeServe holds runners started with Lifecycle.Go() or Supervisor.Go() while modules are starting, until
+// every module has started, so that a half-started application never serves, eg. an HTTP listener doesn't accept
+// connections before metrics and tracing are up.
+//
+// Provided Runners are always run only once every module has started, alongside or after the application module's
+// Start(...). With the barrier, runners started from a module's Start(...) method are also held, then run once every
+// module's Start(...) and AfterStart() methods have returned, before the application module's Start(...) is called.
+// If starting fails, held runners are never run. A module's Start(...) must therefore not wait for a runner it
+// starts, eg. for a server to be listening.
+func (a *Application) BarrierBeforeServe(barrier bool) *Application {
+	a.serveBarrier = barrier
+	return a
+}
+
+var runnersType = reflect.TypeOf([]Runner{})
+
+// runRunners runs all provided Runners, along with any extra runners, until one returns or ctx is cancelled,
+// returning the first error. ctx is cancelled before it returns, even if there are no runners.
+func (a *Application) runRunners(ctx context.Context, cancel func(), injector *syncInjector, extra ...Runner) error {
+	defer cancel()
+	runners := extra
+	if injector.bound[runnersType] {
+		if _, err := injector.Call(func(r []Runner) { runners = append(r, extra...) }); err != nil {
+			return err
+		}
+	}
+	if len(runners) == 0 {
+		return nil
+	}
+	results := make(chan error, len(runners))
+	for _, runner := range runners {
+		go func(runner Runner) { results <- runner(ctx) }(runner)
+	}
+	var err error
+	remaining := len(runners)
+	select {
+	case err = <-results:
+		remaining--
+	case <-ctx.Done():
+	}
+	cancel()
+	for ; remaining > 0; remaining-- {
+		if result := <-results; err == nil || err == context.Canceled {
+			err = result
+		}
+	}
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
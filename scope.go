@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A Scoped module is installed into its own child injector, keeping its bindings private.
+//
+// The module's providers, and bindings made from its Configure(), PostParse() and PreStart() methods, are only
+// visible to the module itself, except for the exported types, which are provided to other modules. This allows
+// two modules to each bind, eg. a *sql.DB, without conflicting. The module's own methods are injected from its
+// child injector, which also has access to all other bindings. Values returned from its Start(...) method are
+// provided to other modules as usual.
+type Scoped interface {
+	// Exports returns the types that are visible to other modules.
+	Exports() []reflect.Type
+}
+
+// Instances is available for injection as *Instances, and resolves values from the private bindings of named
+// Scoped modules.
+//
+// This allows several instances of a module, each binding a value of the same type, eg. a *http.Server, to be
+// installed and injected by name. Each instance is named by its ModuleName(), so instances of the same type should
+// implement Named. For example:
+//
+//		var public *http.Server
+//		err := instances.Get("public", &public)
+//
+// Kingpin flags are derived from the fields of each module, so two instances of the same type declare conflicting
+// flags unless flags are namespaced (see NamespaceFlags()), with each instance's namespace defaulting to its name,
+// eg. --public.http-bind and --admin.http-bind.
+type Instances struct {
+	injector *syncInjector
+	modules  []interface{}
+}
+
+// Get resolves the value of the type pointed to by target from the Scoped module with the given name, storing it
+// in target.
+func (i *Instances) Get(name string, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, not %T", target)
+	}
+	var scope *syncInjector
+	for _, module := range i.modules {
+		if _, ok := module.(Scoped); !ok || ModuleName(module) != name {
+			continue
+		}
+		if scope != nil {
+			return fmt.Errorf("more than one Scoped module is named %q", name)
+		}
+		scope = i.injector.scope(module)
+	}
+	if scope == nil {
+		return fmt.Errorf("no Scoped module is named %q", name)
+	}
+	value, err := scope.get(v.Elem().Type())
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	v.Elem().Set(reflect.ValueOf(value))
+	return nil
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"reflect"
+
+	"gopkg.in/alecthomas/kingpin.v3-unstable"
+)
+
+// The value secret flags are replaced with, eg. in DumpConfig().
+const redacted = "****"
+
+// secretFlags returns the names of the flags of a module's fields tagged with `secret:"true"`, eg. passwords or API
+// keys, including the fields of embedded structs, before any namespace is applied.
+func secretFlags(module interface{}) (map[string]bool, error) {
+	secrets := map[string]bool{}
+	t := reflect.TypeOf(module)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return secrets, nil
+	}
+	return secrets, addSecretFlags(secrets, t)
+}
+
+// addSecretFlags adds the names of the secret flags of struct type t to secrets.
+//
+// Each flag is named by kingpin, by adding the flags of a struct with only the secret field to a scratch kingpin
+// application, so that names match those of the module's flags.
+func addSecretFlags(secrets map[string]bool, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := addSecretFlags(secrets, field.Type); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Tag.Get("secret") != "true" {
+			continue
+		}
+		only := reflect.StructOf([]reflect.StructField{{Name: field.Name, Type: field.Type, Tag: field.Tag}})
+		scratch := kingpin.New("", "")
+		if err := scratch.Struct(reflect.New(only).Interface()); err != nil {
+			return err
+		}
+		for _, flag := range scratch.Model().Flags {
+			if !builtinFlags[flag.Name] {
+				secrets[flag.Name] = true
+			}
+		}
+	}
+	return nil
+}
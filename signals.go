@@ -0,0 +1,50 @@
+package app
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WithSignals enables graceful shutdown when any of the given signals are received.
+//
+// The first signal cancels the root context, which should cause the main module's Start(...) to return and
+// the Stop(...) sequence to run. A second signal terminates the application with a non-zero status.
+//
+// If no signals are provided, SIGINT and SIGTERM are used.
+func (a *Application) WithSignals(signals ...os.Signal) *Application {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	a.signals = signals
+	return a
+}
+
+// handleSignals calls cancel on the first signal received, and terminates on the second.
+//
+// The returned function must be called to stop handling signals.
+func (a *Application) handleSignals(cancel func()) (stop func()) {
+	if len(a.signals) == 0 {
+		return func() {}
+	}
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(signals, a.signals...)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			a.Application.Fatalf("received second %s signal, terminating", sig)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
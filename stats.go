@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// ModuleStat records the time taken by each lifecycle method of a module during the last Run.
+//
+// Durations are zero for methods the module does not have, or that did not complete successfully.
+type ModuleStat struct {
+	// Module is the name of the module's type, eg. "*mongo.Module".
+	Module    string
+	Configure time.Duration
+	Start     time.Duration
+	Stop      time.Duration
+}
+
+// Stats returns the time taken to configure, start and stop each module during the last, or current, Run, in the
+// order modules were installed.
+func (a *Application) Stats() []ModuleStat {
+	a.eventLock.Lock()
+	defer a.eventLock.Unlock()
+	return append([]ModuleStat{}, a.stats...)
+}
+
+// resetStats clears the stats of a previous Run.
+func (a *Application) resetStats() {
+	a.eventLock.Lock()
+	defer a.eventLock.Unlock()
+	a.stats = nil
+	a.statIndex = map[interface{}]int{}
+}
+
+// recordStat records the duration of a lifecycle event. The lock must be held.
+func (a *Application) recordStat(eventType EventType, module interface{}, elapsed time.Duration) {
+	if a.statIndex == nil {
+		return
+	}
+	i, ok := a.statIndex[module]
+	if !ok {
+		i = len(a.stats)
+		a.statIndex[module] = i
+		a.stats = append(a.stats, ModuleStat{Module: fmt.Sprintf("%T", module)})
+	}
+	switch eventType {
+	case EventConfigured:
+		a.stats[i].Configure = elapsed
+	case EventStarted:
+		a.stats[i].Start = elapsed
+	case EventStopped:
+		a.stats[i].Stop = elapsed
+	}
+}
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// Supervisor is available for injection, supervising background goroutines started by modules.
+//
+// Each goroutine is passed the root context, which is cancelled when the application shuts down. If a goroutine
+// returns an error, other than context.Canceled, or panics, the application is shut down and Run returns that
+// error. Run waits for all supervised goroutines to return before stopping modules, so a module's Stop(...) method
+// is never called while its goroutines are still running.
+//
+// Supervised goroutines are tracked alongside runners started with Lifecycle.Go(), which differs only in that
+// panics are not recovered.
+type Supervisor interface {
+	// Go runs f in a supervised goroutine.
+	Go(f func(ctx context.Context) error)
+}
+
+// supervisor implements Supervisor.
+type supervisor struct {
+	app       *Application
+	lifecycle *lifecycle
+}
+
+func (s supervisor) Go(f func(ctx context.Context) error) {
+	s.lifecycle.Go(func(ctx context.Context) (err error) {
+		if !s.app.noRecover {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("supervised goroutine panicked: %v\n%s", r, debug.Stack())
+				}
+			}()
+		}
+		return f(ctx)
+	})
+}
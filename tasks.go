@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"sync"
+)
+
+// Tasks is available for injection, tracking outstanding work that the application should complete before it
+// stops, eg. jobs queued to a worker pool by a batch application.
+//
+// Once the application module's Start(...) method and any Runners have returned successfully, Run waits until
+// every task added has been marked done before stopping modules, as for a sync.WaitGroup. The root context is
+// cancelled once the application module or a Runner returns, so tasks should not observe it. Waiting instead ends
+// early only if the application is shut down, eg. by a signal or Lifecycle.Shutdown().
+type Tasks interface {
+	// Add delta, which may be negative, to the number of outstanding tasks.
+	Add(delta int)
+	// Done marks a task as complete.
+	Done()
+}
+
+// tasks implements Tasks.
+type tasks struct {
+	lock  sync.Mutex
+	count int
+	// Closed when count reaches zero, if anything is waiting.
+	done chan struct{}
+}
+
+func (t *tasks) Add(delta int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.count += delta
+	if t.count < 0 {
+		panic("app: negative Tasks counter")
+	}
+	if t.count == 0 && t.done != nil {
+		close(t.done)
+		t.done = nil
+	}
+}
+
+func (t *tasks) Done() {
+	t.Add(-1)
+}
+
+// wait until there are no outstanding tasks, or ctx is cancelled.
+func (t *tasks) wait(ctx context.Context) {
+	t.lock.Lock()
+	if t.count == 0 {
+		t.lock.Unlock()
+		return
+	}
+	if t.done == nil {
+		t.done = make(chan struct{})
+	}
+	done := t.done
+	t.lock.Unlock()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
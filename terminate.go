@@ -0,0 +1,145 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TerminatedError is returned by Run if the application was terminated while parsing the command-line, eg. after
+// displaying --help or --version, and the function set with Terminate() returned.
+type TerminatedError struct {
+	Status int
+}
+
+func (t TerminatedError) Error() string {
+	return fmt.Sprintf("terminated with status %d", t.Status)
+}
+
+// ExitError is an error carrying the status the application should exit with, eg. returned from an application
+// module's Start(...) method to control the exit status of a tool precisely.
+//
+// It is returned from Application.Run as any other error, but the global Run() prints Err, if any, and exits with
+// Code.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitError returns the ExitError that err is or contains, if any.
+func exitError(err error) (ExitError, bool) {
+	var exit ExitError
+	ok := errors.As(err, &exit)
+	return exit, ok
+}
+
+// Terminate sets the function called to terminate the application, eg. after displaying --help, or from Fatalf().
+//
+// The default is os.Exit. If terminate is nil or returns, Run returns a TerminatedError instead of continuing, so
+// the Application can be embedded or tested without exiting the process.
+func (a *Application) Terminate(terminate func(status int)) *Application {
+	a.terminate = terminate
+	return a
+}
+
+// OnExit registers a function to be called synchronously before the application terminates the process, eg. to
+// flush buffered logs or traces.
+//
+// Unlike Stop(...) methods and Lifecycle.OnShutdown() hooks, which are only called by a graceful shutdown, exit
+// functions are called whenever the terminate function is, including from kingpin's Fatalf() and after --help.
+// They are called in the reverse of the order in which they were registered. They are not called when Run
+// returns normally.
+func (a *Application) OnExit(f func()) *Application {
+	a.runLock.Lock()
+	defer a.runLock.Unlock()
+	a.exitHooks = append(a.exitHooks, f)
+	return a
+}
+
+// handleTerminate is passed to kingpin, recording the termination status before calling the exit functions and
+// the terminate function.
+func (a *Application) handleTerminate(status int) {
+	a.lock.Lock()
+	a.terminated = &status
+	terminate := a.terminate
+	a.lock.Unlock()
+	a.runLock.Lock()
+	hooks := a.exitHooks
+	a.runLock.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i]()
+	}
+	if terminate != nil {
+		terminate(status)
+	}
+}
+
+// checkTerminated returns a TerminatedError if the application has been terminated since the last call.
+func (a *Application) checkTerminated() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	status := a.terminated
+	a.terminated = nil
+	if status == nil {
+		return nil
+	}
+	return TerminatedError{Status: *status}
+}
+
+// Fatalf prints an error message and terminates the application with a non-zero status.
+//
+// While the application is running, it instead calls Lifecycle.Fatalf(), so that modules are stopped and shutdown
+// hooks are run, and Run then returns the error.
+func (a *Application) Fatalf(format string, args ...interface{}) {
+	if lifecycle := a.current(); lifecycle != nil {
+		lifecycle.Fatalf(format, args...)
+		return
+	}
+	a.Application.Fatalf(format, args...)
+}
+
+// FatalIfError prints an error message and terminates the application with a non-zero status, if err is non-nil.
+//
+// The error is prefixed with the formatted message, unless format is empty, in which case only the error is
+// printed. While the application is running, it instead calls Lifecycle.FatalIfError(), as for Fatalf().
+func (a *Application) FatalIfError(err error, format string, args ...interface{}) {
+	if lifecycle := a.current(); lifecycle != nil {
+		lifecycle.FatalIfError(err, format, args...)
+		return
+	}
+	if err != nil {
+		a.Application.Fatalf("%s", prefixError(err, format, args...))
+	}
+}
+
+// prefixError prefixes err with the formatted message, unless format is empty.
+func prefixError(err error, format string, args ...interface{}) error {
+	if format == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %s", fmt.Sprintf(format, args...), err)
+}
+
+// current returns the lifecycle of the running application, if any.
+func (a *Application) current() *lifecycle {
+	a.runLock.Lock()
+	defer a.runLock.Unlock()
+	return a.runCurrent
+}
+
+func (a *Application) setCurrent(lifecycle *lifecycle) {
+	a.runLock.Lock()
+	defer a.runLock.Unlock()
+	a.runCurrent = lifecycle
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// Validate checks that every type required by the installed modules' Provide*(), Start(...) and Stop(...) methods
+// is bound, and that there are no cycles between Provide*() methods, without resolving any of them.
+//
+// Modules are installed and configured into a scratch injector, so their Configure() methods, and any module
+// factories, will be called. Validation is also performed by Run before the command-line is parsed. If any module
+// is a PostParser, missing bindings can't be reported until after parsing, so only cycles are checked.
+func (a *Application) Validate() error {
+	injector, modules, err := a.configureScratch()
+	if err != nil {
+		return err
+	}
+	return validateBindings(injector, modules, false)
+}
+
+// configureScratch installs and configures the modules into a scratch injector, returning it and the installed
+// modules, without registering flags. Deconfigurer modules are deconfigured before returning.
+func (a *Application) configureScratch() (*syncInjector, []interface{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	injector, err := a.newInjector(ctx, &lifecycle{ctx: ctx, cancel: cancel})
+	if err != nil {
+		return nil, nil, err
+	}
+	modules, _, err := a.configureModules(injector, nil, nil)
+	// The modules are never started, so release anything acquired by their Configure() methods.
+	errs := a.deconfigure(injector, nil)
+	if err != nil {
+		errs = append(Errors{err}, errs...)
+	}
+	if err := errs.Err(); err != nil {
+		return nil, nil, err
+	}
+	return injector, modules, nil
+}
+
+// validateBindings checks that every type required by the modules is either bound to the injector or provided by
+// one of the modules, and that there are no cycles between their providers.
+//
+// If the command-line has not been parsed, missing bindings are not reported if any module is a PostParser.
+func validateBindings(injector *syncInjector, modules []interface{}, parsed bool) error {
+	// SelectedCommand, Args, *ParseContext, *Instances and, if no module provides one, Logger are bound after
+	// parsing.
+	bound := map[reflect.Type]bool{
+		reflect.TypeOf(SelectedCommand("")): true, reflect.TypeOf(Args{}): true, reflect.TypeOf(&ParseContext{}): true,
+		reflect.TypeOf(&Instances{}): true, reflect.TypeOf(RawArgs{}): true, loggerType: true,
+	}
+	for t := range injector.bound {
+		bound[t] = true
+	}
+	for _, module := range modules {
+		for _, t := range providedTypes(module) {
+			bound[t] = true
+		}
+	}
+	errs := Errors{}
+	for _, module := range modules {
+		if _, ok := module.(PostParser); ok && !parsed {
+			return findProviderCycle(modules)
+		}
+	}
+	for _, module := range modules {
+		// A Scoped module also has access to its private bindings.
+		private := map[reflect.Type]bool{}
+		if scoped, ok := module.(Scoped); ok {
+			for t := range injector.scope(module).bound {
+				private[t] = true
+			}
+			for _, t := range moduleTypes(module) {
+				private[t] = true
+			}
+			for _, t := range scoped.Exports() {
+				if !private[t] {
+					errs = append(errs, fmt.Errorf("%T exports %s, which is not bound by the module", module, t))
+				}
+			}
+		}
+		for _, r := range requirements(module) {
+			if !bound[r.t] && !private[r.t] {
+				errs = append(errs, fmt.Errorf("%T.%s() requires %s, which is not bound by any module", module,
+					r.method, r.t))
+			}
+		}
+	}
+	if err := findProviderCycle(modules); err != nil {
+		errs = append(errs, err)
+	}
+	return errs.Err()
+}
+
+// validateModules calls the Validate() method of each Validator module, collecting any errors.
+func (a *Application) validateModules(modules []interface{}) error {
+	errs := Errors{}
+	for _, module := range modules {
+		if validator, ok := module.(Validator); ok {
+			if err := a.guard(module, "Validate", validator.Validate); err != nil {
+				errs = append(errs, fmt.Errorf("%T: %s", module, err))
+			}
+		}
+	}
+	return errs.Err()
+}
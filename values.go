@@ -0,0 +1,60 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Values holds values shared between modules, eg. feature flags or tenant configuration, as a lighter-weight
+// alternative to providing a type for each value.
+//
+// Modules set values from their Configure() method, using the Values returned by ConfigValues(binder). Once every
+// module has been configured, the Values are frozen and available for injection as *Values, eg. into Start(...),
+// where they are read-only.
+type Values struct {
+	lock   sync.RWMutex
+	values map[string]interface{}
+	frozen bool
+}
+
+// ConfigValues returns the Values of the application being configured with binder, for use from a module's
+// Configure() method. It returns nil if binder was not passed to Configure() by an Application.
+func ConfigValues(binder Binder) *Values {
+	injector, ok := binder.(*syncInjector)
+	if !ok {
+		return nil
+	}
+	for injector.parent != nil {
+		injector = injector.parent
+	}
+	return injector.values
+}
+
+// Set the value of key, replacing any previous value. It returns an error once the Values are frozen.
+func (v *Values) Set(key string, value interface{}) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.frozen {
+		return fmt.Errorf("can't set value %q as values are frozen once modules are configured", key)
+	}
+	if v.values == nil {
+		v.values = map[string]interface{}{}
+	}
+	v.values[key] = value
+	return nil
+}
+
+// Get the value of key, and whether it is set.
+func (v *Values) Get(key string) (interface{}, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	value, ok := v.values[key]
+	return value, ok
+}
+
+// freeze the values, preventing further changes.
+func (v *Values) freeze() {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.frozen = true
+}